@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envString returns the environment variable name, or def when unset
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envUint parses an unsigned environment variable, exiting on garbage
+func envUint(name string, def uint64) uint64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		log.Fatalf("invalid %s=%q: %v", name, v, err)
+	}
+	return n
+}
+
+// envFloat parses a floating point environment variable, exiting on garbage
+func envFloat(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatalf("invalid %s=%q: %v", name, v, err)
+	}
+	return f
+}
+
+// envBool parses a boolean environment variable (1, true, 0, false...)
+func envBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("invalid %s=%q: %v", name, v, err)
+	}
+	return b
+}
+
+// envPairs parses a comma separated list of name=value pairs, in order
+func envPairs(name string) [][2]string {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	var out [][2]string
+	for _, item := range strings.Split(v, ",") {
+		k, val, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || k == "" || val == "" {
+			log.Fatalf("invalid %s entry %q, want name=value", name, item)
+		}
+		out = append(out, [2]string{k, val})
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"entropy-service/rng"
+)
+
+// connGenerators derives one generator per connection from the master and
+// wipes its key material as soon as the connection goes away. HTTP and HTTPS
+// servers use the same derivation.
+type connGenerators struct {
+	master rng.Generator
+	m      sync.Map // net.Conn -> rng.Generator
+}
+
+func newConnGenerators(master rng.Generator) *connGenerators {
+	return &connGenerators{master: master}
+}
+
+// connContext is installed as http.Server.ConnContext. If derivation fails
+// the connection is still served, handlers fall back to per-request children.
+func (cg *connGenerators) connContext(ctx context.Context, c net.Conn) context.Context {
+	g, err := rng.NewConnectionDRBG(cg.master)
+	if err != nil {
+		log.Println("per-connection DRBG:", err)
+		return ctx
+	}
+	cg.m.Store(c, g)
+	return rng.ContextWithGenerator(ctx, g)
+}
+
+// connState is installed as http.Server.ConnState. Hijacked connections are
+// wiped too, no handler keeps using the generator after a hijack.
+func (cg *connGenerators) connState(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		if g, ok := cg.m.LoadAndDelete(c); ok {
+			g.(rng.Generator).Zeroize()
+		}
+	}
+}
+
+// zeroizeAll wipes whatever is left, used after the server has shut down
+func (cg *connGenerators) zeroizeAll() {
+	cg.m.Range(func(c, g any) bool {
+		cg.m.Delete(c)
+		g.(rng.Generator).Zeroize()
+		return true
+	})
+}
+
+// connGenerator returns the generator of the connection r arrived on, or
+// fallback when the connection has none
+func connGenerator(r *http.Request, fallback rng.Generator) rng.Generator {
+	if g, ok := rng.GeneratorFromContext(r.Context()); ok {
+		return g
+	}
+	return fallback
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"entropy-service/rng"
+)
+
+// TestConnectionGenerators checks that requests on one connection share a
+// generator, that two connections get different ones with different output,
+// and that a generator is wiped when its connection closes
+func TestConnectionGenerators(t *testing.T) {
+	master, err := rng.NewDRBGWithAlgo(rng.AlgoChaCha20, make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Zeroize()
+
+	var mu sync.Mutex
+	seen := make(map[rng.Generator]int)
+	random := randomBytesHandler(master, &predictionResistance{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g, ok := rng.GeneratorFromContext(r.Context())
+		if !ok {
+			t.Error("request without a connection generator")
+		}
+		mu.Lock()
+		seen[g]++
+		mu.Unlock()
+		random(w, r)
+	})
+
+	conns := newConnGenerators(master)
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.ConnContext = conns.connContext
+	srv.Config.ConnState = conns.connState
+	srv.Start()
+	defer srv.Close()
+
+	get := func(c *http.Client) []byte {
+		resp, err := c.Get(srv.URL + "/v1/random?bytes=64")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil || len(b) != 64 {
+			t.Fatalf("read %d bytes: %v", len(b), err)
+		}
+		return b
+	}
+
+	// one keep-alive connection per client
+	a := &http.Client{Transport: &http.Transport{MaxConnsPerHost: 1}}
+	b := &http.Client{Transport: &http.Transport{MaxConnsPerHost: 1}}
+	a1, a2 := get(a), get(a)
+	b1 := get(b)
+
+	if bytes.Equal(a1, b1) || bytes.Equal(a1, a2) {
+		t.Error("repeated output across requests")
+	}
+	var gens []rng.Generator
+	for g, n := range seen {
+		gens = append(gens, g)
+		if n != 1 && n != 2 {
+			t.Errorf("generator used for %d requests", n)
+		}
+	}
+	if len(gens) != 2 || seen[gens[0]]+seen[gens[1]] != 3 {
+		t.Fatalf("requests per generator %v, want 2 and 1", seen)
+	}
+
+	// closing the connections wipes their generators
+	a.CloseIdleConnections()
+	b.CloseIdleConnections()
+	deadline := time.Now().Add(2 * time.Second)
+	for _, g := range gens {
+		for g.Generate(make([]byte, 1), nil) == nil {
+			if time.Now().After(deadline) {
+				t.Fatal("generator still usable after its connection closed")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
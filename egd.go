@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"entropy-service/rng"
+)
+
+// EGD (Entropy Gathering Daemon) commands
+const (
+	egdEntropyCount = 0x00 // -> 4 byte big-endian count of bits available
+	egdReadNonblock = 0x01 // n -> 1 byte length, then up to n bytes
+	egdReadBlock    = 0x02 // n -> exactly n bytes
+	egdWriteEntropy = 0x03 // not supported, closes the connection
+	egdPID          = 0x04 // -> 1 byte length, then the pid in ASCII
+)
+
+// egdReseedBytes of fresh QRNG entropy are mixed in before a blocking read
+const egdReseedBytes = 64
+
+// egdRetryInterval is how often a blocking read asks a failing source again
+const egdRetryInterval = 100 * time.Millisecond
+
+var egdCommands = [...]string{"entropy_count", "read_nonblocking", "read_blocking", "write_entropy", "pid"}
+
+// egdServer speaks the EGD socket protocol for legacy clients (old OpenSSL,
+// GnuPG). Like the HTTP servers every connection draws from its own DRBG
+// derived from the master. A non-blocking read is answered from it right
+// away; a blocking read first reseeds it with fresh conditioned QRNG
+// entropy, waiting for the buffer and retrying a failing source until
+// shutdown. The entropy count is what the QRNG buffer holds.
+type egdServer struct {
+	ctx    context.Context
+	ln     net.Listener
+	master rng.Generator
+	buf    *rng.QRNGBuffer
+	src    *rng.Conditioner
+	idle   time.Duration
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// startEGD listens on network ("unix" or "tcp") at addr. A stale Unix socket
+// is replaced and made accessible to every local user, as egd does.
+func startEGD(ctx context.Context, network, addr string, master rng.Generator, buf *rng.QRNGBuffer, src *rng.Conditioner, idle time.Duration) (*egdServer, error) {
+	if network == "unix" {
+		if fi, err := os.Lstat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(addr)
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		if err := os.Chmod(addr, 0o666); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	s := &egdServer{
+		ctx:    ctx,
+		ln:     ln,
+		master: master,
+		buf:    buf,
+		src:    src,
+		idle:   idle,
+		conns:  make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *egdServer) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("EGD accept error: %v", err)
+			}
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		atomic.AddUint64(&egdConnections, 1)
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(c)
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+			c.Close()
+		}()
+	}
+}
+
+// handle answers commands on c until the client hangs up or misbehaves
+func (s *egdServer) handle(c net.Conn) {
+	gen, err := rng.NewConnectionDRBG(s.master)
+	if err != nil {
+		log.Println("EGD per-connection DRBG:", err)
+		return
+	}
+	defer gen.Zeroize()
+
+	r := bufio.NewReader(c)
+	out := make([]byte, 1+math.MaxUint8)
+	defer clear(out)
+	for {
+		if s.idle > 0 {
+			c.SetReadDeadline(time.Now().Add(s.idle))
+		}
+		cmd, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		if int(cmd) < len(egdCommands) {
+			atomic.AddUint64(&egdRequests[cmd], 1)
+		}
+
+		var reply []byte
+		switch cmd {
+		case egdEntropyCount:
+			reply = binary.BigEndian.AppendUint32(out[:0], s.entropyBits())
+
+		case egdReadNonblock, egdReadBlock:
+			n, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			if cmd == egdReadNonblock {
+				// a failing DRBG answers 0 bytes, which EGD allows
+				out[0] = 0
+				if gen.Generate(out[1:1+int(n)], nil) == nil {
+					out[0] = n
+				}
+				reply = out[:1+int(out[0])]
+				n = out[0]
+			} else {
+				if err := s.reseed(gen); err != nil {
+					log.Printf("EGD blocking read: %v", err)
+					return
+				}
+				if err := gen.Generate(out[:n], nil); err != nil {
+					log.Printf("EGD blocking read: %v", err)
+					return
+				}
+				reply = out[:n]
+			}
+			// random bytes only, not the length prefix of a non-blocking reply
+			atomic.AddUint64(&egdBytes, uint64(n))
+			atomic.AddUint64(&rngBytesGenerated, uint64(n))
+
+		case egdPID:
+			pid := strconv.Itoa(os.Getpid())
+			reply = append(append(out[:0], byte(len(pid))), pid...)
+
+		default:
+			// including 0x03, client entropy is not mixed into the DRBGs
+			log.Printf("EGD: unsupported command %#02x from %s", cmd, c.RemoteAddr())
+			return
+		}
+
+		if _, err := c.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// entropyBits is the entropy the QRNG buffer holds, at the claimed
+// min-entropy
+func (s *egdServer) entropyBits() uint32 {
+	bits := float64(s.buf.Len()) * s.buf.Stats().MinEntropy
+	return uint32(min(bits, math.MaxUint32))
+}
+
+// reseed mixes fresh conditioned entropy into gen, waiting for it until
+// shutdown. While the source is failing the buffer gives up right away, so
+// it is asked again every egdRetryInterval.
+func (s *egdServer) reseed(gen rng.Generator) error {
+	entropy := make([]byte, egdReseedBytes)
+	defer clear(entropy)
+	for {
+		err := s.src.ReadContext(s.ctx, entropy)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, rng.ErrSourceUnavailable) {
+			return err
+		}
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-time.After(egdRetryInterval):
+		}
+	}
+	return gen.Reseed(entropy, nil)
+}
+
+// Close stops accepting, hangs up on every client and waits for the
+// handlers, so their DRBGs are wiped before the master
+func (s *egdServer) Close() {
+	s.ln.Close()
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Addr returns where the server listens
+func (s *egdServer) Addr() net.Addr {
+	return s.ln.Addr()
+}
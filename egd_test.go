@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"entropy-service/rng"
+)
+
+func TestEGD(t *testing.T) {
+	master, err := rng.NewDRBGWithAlgo(rng.AlgoChaCha20, make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Zeroize()
+	buf := rng.NewQRNGBuffer(rng.FromReader(rand.Reader), 4096)
+	defer buf.Stop()
+	fn, err := rng.NewConditioningFunction(rng.CondHMACSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sock := filepath.Join(t.TempDir(), "egd-pool")
+	s, err := startEGD(ctx, "unix", sock, master, buf, rng.NewConditioner(buf, fn, 8), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ask := func(req []byte, n int) []byte {
+		t.Helper()
+		if _, err := c.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, n)
+		if _, err := io.ReadFull(c, reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+	bytesBefore := atomic.LoadUint64(&egdBytes)
+
+	// non-blocking: length prefix, then the bytes; only those are counted
+	if r := ask([]byte{egdReadNonblock, 10}, 11); r[0] != 10 {
+		t.Fatalf("non-blocking read answered %d bytes, want 10", r[0])
+	}
+	if n := atomic.LoadUint64(&egdBytes) - bytesBefore; n != 10 {
+		t.Errorf("%d bytes counted for a 10 byte non-blocking read", n)
+	}
+
+	// blocking: exactly the bytes asked for
+	ask([]byte{egdReadBlock, 20}, 20)
+	if n := atomic.LoadUint64(&egdBytes) - bytesBefore; n != 30 {
+		t.Errorf("%d bytes counted after 10 + 20", n)
+	}
+
+	ask([]byte{egdEntropyCount}, 4)
+	pid := strconv.Itoa(os.Getpid())
+	if r := ask([]byte{egdPID}, 1+len(pid)); string(r[1:]) != pid {
+		t.Errorf("pid %q, want %q", r[1:], pid)
+	}
+
+	// writing entropy is refused by hanging up
+	c.Write([]byte{egdWriteEntropy})
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v after write_entropy, want EOF", err)
+	}
+}
+
+// brokenSource fails while broken is set, then reads crypto/rand
+type brokenSource struct{ broken atomic.Bool }
+
+func (s *brokenSource) Read(p []byte) error {
+	if s.broken.Load() {
+		return errors.New("broken")
+	}
+	_, err := rand.Read(p)
+	return err
+}
+
+// TestEGDBlockingReadRetries checks that a blocking read waits out a failing
+// source instead of hanging up
+func TestEGDBlockingReadRetries(t *testing.T) {
+	master, err := rng.NewDRBGWithAlgo(rng.AlgoChaCha20, make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Zeroize()
+	src := &brokenSource{}
+	src.broken.Store(true)
+	buf := rng.NewQRNGBuffer(src, 4096)
+	defer buf.Stop()
+	fn, err := rng.NewConditioningFunction(rng.CondHMACSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for buf.Stats().ReadErrors == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sock := filepath.Join(t.TempDir(), "egd-pool")
+	s, err := startEGD(ctx, "unix", sock, master, buf, rng.NewConditioner(buf, fn, 8), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		s.Close()
+	}()
+
+	c, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte{egdReadBlock, 16}); err != nil {
+		t.Fatal(err)
+	}
+
+	// still waiting, not hung up, after a few retries
+	c.SetReadDeadline(time.Now().Add(3 * egdRetryInterval))
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v while the source fails, want a read timeout", err)
+	}
+
+	src.broken.Store(false)
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(c, make([]byte, 16)); err != nil {
+		t.Fatalf("blocking read after the source recovered: %v", err)
+	}
+}
@@ -2,22 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/sha512"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
 	"encoding/json"
 	"entropy-service/rng"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/png"
-	"net"
 	// remove below comment to enable HTTP/2
 	//"golang.org/x/net/http2"
 	"log"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"slices"
 	"strconv"
-	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -35,20 +40,81 @@ type HealthInfo struct {
 	ReseedSizeBits       int    `json:"reseed_size_bits"`
 	EntropyBufferedBytes int    `json:"entropy_buffered_kb"`
 	EntropyBufferedPCT   int    `json:"entropy_buffered_pct"`
+	ReseedCounter        uint64 `json:"reseed_counter"`
+	MaxGenerates         uint64 `json:"max_generates_per_reseed"`
+	BytesSinceReseed     uint64 `json:"bytes_since_reseed"`
+	MaxBytesPerReseed    uint64 `json:"max_bytes_per_reseed"`
+	ForcedReseeds        uint64 `json:"forced_reseeds"`
+	RefusedRequests      uint64 `json:"refused_requests"`
+	Shards               int    `json:"drbg_shards"`
+	QRNGReadErrors       uint64 `json:"qrng_read_errors"`
+	QRNGLastError        string `json:"qrng_last_error,omitempty"`
+	QRNGSourceError      string `json:"qrng_source_error,omitempty"`
+	ReseedError          string `json:"reseed_error,omitempty"`
+
+	Sources     []rng.FailoverStatus `json:"sources"`
+	HealthTests healthTests          `json:"health_tests"`
+	SelfTests   []rng.SelfTest       `json:"self_tests"`
+	KernelFeed  *rng.KernelFeedStats `json:"kernel_feed,omitempty"`
 }
 
-// Buffered QRNG struct
-type QRNGBuffer struct {
-	buf       []byte
-	mu        sync.Mutex
-	capacity  int
-	fillDelay time.Duration
-	devPath   string
-	stop      chan struct{}
+// healthTests is the SP 800-90B continuous health test section of /health
+type healthTests struct {
+	Status         string  `json:"status"`
+	LastFailure    string  `json:"last_failure,omitempty"`
+	MinEntropy     float64 `json:"claimed_min_entropy"`
+	RCTCutoff      int     `json:"rct_cutoff"`
+	APTCutoff      int     `json:"apt_cutoff"`
+	RCTFailures    uint64  `json:"rct_failures"`
+	APTFailures    uint64  `json:"apt_failures"`
+	RejectedBlocks uint64  `json:"rejected_blocks"`
 }
 
-// maps to older fetchEntropy
-var qrngBuffer *QRNGBuffer
+// worstStatus returns the most severe of ok, degraded and failed
+func worstStatus(statuses ...string) string {
+	worst := "ok"
+	for _, s := range statuses {
+		switch {
+		case s == "failed":
+			return s
+		case s == "degraded":
+			worst = s
+		}
+	}
+	return worst
+}
+
+// sourceStatus is "ok" while the primary source is in use, "degraded" on a
+// fallback or with a source quarantined, "failed" when none is left
+func sourceStatus(sources []rng.FailoverStatus) string {
+	status := "ok"
+	quarantined := 0
+	for i, s := range sources {
+		if s.Quarantined {
+			quarantined++
+			status = "degraded"
+		}
+		if s.Active && i > 0 {
+			status = "degraded"
+		}
+	}
+	if quarantined == len(sources) {
+		return "failed"
+	}
+	return status
+}
+
+// QRNG buffer and the conditioner behind fetchEntropy, set up in main,
+// and the network sources closed on shutdown
+var (
+	qrngBuffer  *rng.QRNGBuffer
+	conditioner *rng.Conditioner
+	netSources  []*rng.NetSource
+)
+
+// entropyTimeout bounds fetchEntropy, so startup fails instead of hanging
+// when no source delivers
+const entropyTimeout = 10 * time.Second
 
 func popcount(b byte) int {
 	b = b - ((b >> 1) & 0x55)
@@ -135,134 +201,150 @@ func fetchEntropy(n int) ([]byte, error) {
 }
 */
 
-func initQRNGBuffer() {
-	// For example, 64 KB buffer. 2MB for testing purposes
-	qrngBuffer = NewQRNGBuffer("/dev/qrandom0", 2*1024*1024)
-
-	// Attach it to DRBG
-	//drbg.SetEntropyBuffer(qrngBuffer)
-}
+// fetchEntropy reads n bytes of conditioned entropy from the buffered QRNG,
+// giving up after entropyTimeout or when ctx is done
+func fetchEntropy(ctx context.Context, n int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, entropyTimeout)
+	defer cancel()
 
-// NewQRNGBuffer creates a buffered QRNG reader
-func NewQRNGBuffer(dev string, capacity int) *QRNGBuffer {
-	q := &QRNGBuffer{
-		buf:       make([]byte, 0, capacity),
-		capacity:  capacity,
-		fillDelay: 10 * time.Millisecond,
-		devPath:   dev,
-		stop:      make(chan struct{}),
+	incTestA(n)
+	b := make([]byte, n)
+	err := conditioner.ReadContext(ctx, b)
+	incTestB(qrngBuffer.Len())
+	if err != nil {
+		clear(b)
+		return nil, err
 	}
-
-	// Start background fill
-	go q.fillLoop()
-	atomic.AddUint64(&rngBytesBuffered, uint64((q.capacity)))
-	//incBuffer()
-	return q
+	return b, nil
 }
 
-// Stop stops the background fill goroutine
-func (q *QRNGBuffer) Stop() {
-	close(q.stop)
+// seedFileWait bounds the one wait for fresh entropy at startup when a
+// saved seed is available, the sources catch up through the reseed loop
+const seedFileWait = 2 * time.Second
+
+// startupEntropy fetches the fresh entropy all shards share when a seed file
+// is used, waiting at most seedFileWait. It returns nil if none arrived.
+func startupEntropy(ctx context.Context, n int) []byte {
+	wctx, cancel := context.WithTimeout(ctx, seedFileWait)
+	defer cancel()
+	fresh, err := fetchEntropy(wctx, n)
+	if err != nil {
+		log.Printf("shards seeded from the seed file only: %v", err)
+		return nil
+	}
+	return fresh
 }
 
-// Get returns n bytes from the buffer, blocking if necessary
-func (q *QRNGBuffer) Get(n int) ([]byte, error) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+// initialSeed returns n bytes to instantiate one shard. Without a saved seed
+// it is fresh conditioned entropy. With one, the saved seed, the shard index
+// and the fresh entropy from startupEntropy (possibly none) are hashed
+// together, so a missing source delays startup by seedFileWait at most.
+func initialSeed(ctx context.Context, n int, saved, fresh []byte, shard int) ([]byte, error) {
+	if saved == nil {
+		return fetchEntropy(ctx, n)
+	}
 
-	// Wait for buffer to fill if necessary
-	for len(q.buf) < n {
-		q.mu.Unlock()
-		time.Sleep(q.fillDelay)
-		q.mu.Lock()
+	out := make([]byte, 0, n+sha512.Size)
+	for ctr := uint32(0); len(out) < n; ctr++ {
+		h := sha512.New()
+		h.Write(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, ctr), uint32(shard)))
+		h.Write(saved)
+		h.Write(fresh)
+		out = h.Sum(out)
 	}
+	clear(out[n:])
+	return out[:n], nil
+}
 
-	out := q.buf[:n]
-	q.buf = q.buf[n:]
-	incTestB(len(q.buf))
-	return out, nil
+// saveSeed writes a fresh seed file from g, logging failures
+func saveSeed(sf *rng.SeedFile, g rng.Generator) {
+	if err := sf.Save(g); err != nil {
+		log.Printf("seed file %s not saved: %v", sf.Path(), err)
+	}
 }
 
-// fillLoop continuously fills the buffer from the QRNG device
-func (q *QRNGBuffer) fillLoop() {
+// seedFileLoop refreshes the seed file every interval, so a crash loses at
+// most one interval of saved state
+func seedFileLoop(ctx context.Context, sf *rng.SeedFile, g rng.Generator, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case <-q.stop:
+		case <-ctx.Done():
 			return
-		default:
-		}
-
-		q.mu.Lock()
-		free := q.capacity - len(q.buf)
-		q.mu.Unlock()
-
-		if free <= 0 {
-			time.Sleep(q.fillDelay)
-			continue
-		}
-
-		tmp := make([]byte, free)
-		f, err := os.Open(q.devPath)
-		if err != nil {
-			time.Sleep(50 * time.Millisecond) // retry
-			continue
-		}
-
-		total := 0
-		for total < free {
-			m, err := f.Read(tmp[total:])
-			if err != nil {
-				break
-			}
-			total += m
-			incTestA(m)
+		case <-ticker.C:
+			saveSeed(sf, g)
 		}
-		f.Close()
-
-		q.mu.Lock()
-		q.buf = append(q.buf, tmp[:total]...)
-		q.mu.Unlock()
 	}
 }
 
-// fetchEntropy reads n bytes from the buffered QRNG
-func fetchEntropy(n int) ([]byte, error) {
-	if qrngBuffer == nil {
-		initQRNGBuffer()
-	}
-	//atomic.AddUint64(&rngBufferSize, uint64(len(qrngBuffer)))
-	incTestA(n)
-	return qrngBuffer.Get(n)
-}
+// reseedStarvedTicks is how many ticks in a row the reseed loop waits for
+// the entropy pools before reseeding straight from the conditioner
+const reseedStarvedTicks = 3
+
+// reseedError holds the last reseed failure, empty once a reseed succeeds
+var reseedError atomic.Value
 
 // reseed loop default interval: 250ms
-func reseedLoop(ctx context.Context, d *rng.DRBG) {
+// Each tick takes one Fortuna reseed from the accumulator, mixed into every
+// shard of the pool with the shard index as additional input. When the pools
+// stay empty the loop draws conditioned entropy directly, bounded by the
+// tick, so a dead source shows up as logged failures and a degraded health
+// state instead of a silently stale DRBG.
+func reseedLoop(ctx context.Context, p *rng.Pool, acc *rng.Accumulator) {
 	//ticker := time.NewTicker(10 * time.Second)
-	ticker := time.NewTicker(2000 * time.Millisecond)
+	const interval = 2000 * time.Millisecond
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	starved := 0
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
 		case <-ticker.C:
-			for range ticker.C {
-				atomic.AddUint64(&rngReseeds, +1)
-				entropy, err := fetchEntropy(64)
-				if err != nil {
-					log.Println("entropy fetch failed:", err)
+			seed, ok := acc.Seed()
+			if !ok {
+				if starved++; starved < reseedStarvedTicks {
+					log.Println("reseed skipped: entropy pools not ready")
 					continue
 				}
-				if err := d.Reseed(entropy); err != nil {
-					log.Println("reseed failed:", err)
+				tctx, cancel := context.WithTimeout(ctx, interval)
+				var err error
+				seed, err = fetchEntropy(tctx, 64)
+				cancel()
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					reseedFailed(err)
+					continue
 				}
 			}
+			starved = 0
+
+			atomic.AddUint64(&rngReseeds, uint64(len(p.Shards())))
+			err := p.Reseed(seed, nil)
+			clear(seed)
+			if err != nil {
+				reseedFailed(err)
+				continue
+			}
+			reseedError.Store("")
 		}
 	}
 }
 
-func entropyHeatmapHandler(d *rng.DRBG) http.HandlerFunc {
+// reseedFailed logs and records a failed reseed
+func reseedFailed(err error) {
+	log.Println("reseed failed:", err)
+	atomic.AddUint64(&rngReseedFailures, 1)
+	reseedError.Store(err.Error())
+}
+
+func entropyHeatmapHandler(g rng.Generator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		width := 1024
 		height := 1024
@@ -270,7 +352,10 @@ func entropyHeatmapHandler(d *rng.DRBG) http.HandlerFunc {
 		img := image.NewRGBA(image.Rect(0, 0, width, height))
 
 		buf := make([]byte, width*height)
-		d.Read(buf)
+		if err := connGenerator(r, g).Generate(buf, nil); err != nil {
+			http.Error(w, "DRBG unavailable", http.StatusServiceUnavailable)
+			return
+		}
 
 		i := 0
 		for y := 0; y < height; y++ {
@@ -293,7 +378,7 @@ func entropyHeatmapHandler(d *rng.DRBG) http.HandlerFunc {
 		w.Header().Set("Refresh", "5")
 		w.Header().Set("X-Entropy-Metric", "bit-popcount")
 		w.Header().Set("X-RNG-Reseed-Age-ms",
-			strconv.FormatInt(d.ReseedAge().Milliseconds(), 10))
+			strconv.FormatInt(g.Metadata().ReseedAge.Milliseconds(), 10))
 		png.Encode(w, img)
 	}
 }
@@ -320,7 +405,7 @@ func randomImageHandler(d *rng.DRBG) http.HandlerFunc {
 }
 */
 
-func randomImageHandler(d *rng.DRBG) http.HandlerFunc {
+func randomImageHandler(g rng.Generator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		width := 1024
 		height := 1024
@@ -328,7 +413,10 @@ func randomImageHandler(d *rng.DRBG) http.HandlerFunc {
 		img := image.NewRGBA(image.Rect(0, 0, width, height))
 
 		// Fill the entire backing buffer with DRBG output
-		d.Read(img.Pix)
+		if err := connGenerator(r, g).Generate(img.Pix, nil); err != nil {
+			http.Error(w, "DRBG unavailable", http.StatusServiceUnavailable)
+			return
+		}
 
 		// Force alpha channel to opaque
 		for y := 0; y < height; y++ {
@@ -342,12 +430,12 @@ func randomImageHandler(d *rng.DRBG) http.HandlerFunc {
 		w.Header().Set("Refresh", "5")
 		w.Header().Set("X-Entropy-Metric", "random-image")
 		w.Header().Set("X-RNG-Reseed-Age-ms-test",
-			strconv.FormatInt(d.ReseedAge().Milliseconds(), 10))
+			strconv.FormatInt(g.Metadata().ReseedAge.Milliseconds(), 10))
 		png.Encode(w, img)
 	}
 }
 
-func randomHandler(d *rng.DRBG) http.HandlerFunc {
+func randomHandler(g rng.Generator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		n := 1024
 		if q := r.URL.Query().Get("bytes"); q != "" {
@@ -357,11 +445,44 @@ func randomHandler(d *rng.DRBG) http.HandlerFunc {
 		}
 
 		buf := make([]byte, n)
-		d.Read(buf)
+		if err := connGenerator(r, g).Generate(buf, nil); err != nil {
+			http.Error(w, "DRBG unavailable", http.StatusServiceUnavailable)
+			return
+		}
 		w.Header().Set("Content-Type", "application/octet-stream")
 		w.Header().Set("X-Entropy-Metric", "random-data")
 		w.Header().Set("X-RNG-Reseed-Age-ms-test",
-			strconv.FormatInt(d.ReseedAge().Milliseconds(), 10))
+			strconv.FormatInt(g.Metadata().ReseedAge.Milliseconds(), 10))
+		w.Write(buf)
+	}
+}
+
+// conditionedTimeout bounds the wait for raw input on /v1/conditioned
+const conditionedTimeout = 2 * time.Second
+
+// conditionedHandler serves full-entropy output of the conditioning stage,
+// raw QRNG input that never went through a DRBG
+func conditionedHandler(c *rng.Conditioner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := 32
+		if q := r.URL.Query().Get("bytes"); q != "" {
+			if v, err := strconv.Atoi(q); err == nil && v > 0 && v <= 1<<16 {
+				n = v
+			}
+		}
+
+		buf := make([]byte, n)
+		defer clear(buf)
+		if err := c.ReadTimeout(buf, conditionedTimeout); err != nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "conditioned entropy unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		st := c.Stats()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-RNG-Conditioner", st.Function)
+		w.Header().Set("X-RNG-Conditioning-Ratio", strconv.FormatFloat(st.Ratio, 'f', 3, 64))
+		w.Header().Set("X-RNG-Min-Entropy", strconv.FormatFloat(st.MinEntropy, 'f', 3, 64))
 		w.Write(buf)
 	}
 }
@@ -378,18 +499,65 @@ func randomBytesHandler(d *rng.DRBG) http.HandlerFunc {
 }
 */
 
-func randomBytesHandler(d *rng.DRBG) http.HandlerFunc {
+// predictionResistance configures reseeding from fresh QRNG entropy before
+// generating, on request (?pr=1 or X-RNG-Prediction-Resistance: 1) or always
+type predictionResistance struct {
+	src     *rng.Conditioner
+	always  bool          // server default, clients cannot opt out
+	timeout time.Duration // max wait for fresh entropy before answering 503
+}
+
+// requested reports whether r asks for prediction resistance
+func (pr *predictionResistance) requested(r *http.Request) bool {
+	if pr.always {
+		return true
+	}
+	v := r.URL.Query().Get("pr")
+	if v == "" {
+		v = r.Header.Get("X-RNG-Prediction-Resistance")
+	}
+	on, _ := strconv.ParseBool(v)
+	return on
+}
+
+func randomBytesHandler(g rng.Generator, pr *predictionResistance) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// write heeaders immediately
-		d.WriteHeaders(w)
+		g.Metadata().WriteHeaders(w)
 		w.Header().Set("Content-Type", "application/octet-stream")
-		// Derive 32 bytes from master
-		seed, _ := d.Derive(32)
-		//nonce, _ := d.Derive(12)
+		// Draw from the connection's DRBG, or from a per-request child derived
+		// from master when the connection has none
+		gen, ok := rng.GeneratorFromContext(r.Context())
+		if !ok {
+			child, err := rng.NewConnectionDRBG(g)
+			if err != nil {
+				http.Error(w, "DRBG unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			defer child.Zeroize()
+			gen = child
+		}
 
-		// Create per-request DRBG
-		child, _ := rng.NewDRBG(seed)
-		//connDRBG := r.Context().Value("conn_drbg").(*rng.DRBG)
+		// Prediction resistance: mix fresh hardware entropy right before generating
+		if pr.requested(r) {
+			atomic.AddUint64(&rngPRRequests, 1)
+			// one deadline for all of the raw input, or the client hanging up
+			entropy := make([]byte, 64)
+			ctx, cancel := context.WithTimeout(r.Context(), pr.timeout)
+			err := pr.src.ReadContext(ctx, entropy)
+			cancel()
+			if err == nil {
+				err = gen.Reseed(entropy, nil)
+			}
+			clear(entropy)
+			if err != nil {
+				atomic.AddUint64(&rngPRFailures, 1)
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "fresh entropy unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("X-RNG-Prediction-Resistance", "1")
+		}
 
 		size := 4096
 		if q := r.URL.Query().Get("bytes"); q != "" {
@@ -397,24 +565,28 @@ func randomBytesHandler(d *rng.DRBG) http.HandlerFunc {
 				size = v
 			}
 		}
-		buf := make([]byte, size)
+		w.Header().Set("Content-Length", strconv.Itoa(size))
 
-		child.Read(buf)
-		atomic.AddUint64(&rngBytesGenerated, uint64(len(buf)))
+		// Stream through pooled buffers, nothing is written if the DRBG fails
+		n, err := rng.CopyN(w, gen, int64(size))
+		if err != nil && n == 0 {
+			w.Header().Del("Content-Length")
+			http.Error(w, "DRBG unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		atomic.AddUint64(&rngBytesGenerated, uint64(n))
 		atomic.AddUint64(&httpRequests, +1)
-
-		w.Write(buf)
 	}
 }
 
-func metricsHandler(d *rng.DRBG) http.HandlerFunc {
+func metricsHandler(g rng.Generator, buf *rng.QRNGBuffer, acc *rng.Accumulator, fo *rng.Failover, cond *rng.Conditioner, kf *rng.KernelFeeder) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		metrics := d.GetMetadata()
+		metrics := g.Metadata()
 
 		bytes := atomic.LoadUint64(&rngBytesGenerated) / 1024 / 1024
 		reseeds := atomic.LoadUint64(&rngReseeds)
 		//age := metrics.ReseedAgeMs
-		age := d.ReseedAge().Milliseconds()
+		age := metrics.ReseedAge.Milliseconds()
 		bufBytes := metrics.EntropyBufferedBytes / 1024
 		bufCap := metrics.EntropyFillPct
 		reqs := atomic.LoadUint64(&httpRequests)
@@ -431,6 +603,10 @@ rng_mb_generated_total %d
 # TYPE rng_reseeds_total counter
 rng_reseeds_total %d
 
+# HELP rng_reseed_failures_total Reseeds that failed or found no entropy
+# TYPE rng_reseed_failures_total counter
+rng_reseed_failures_total %d
+
 # HELP rng_reseed_age_ms Age since last reseed
 # TYPE rng_reseed_age_ms gauge
 rng_reseed_age_ms %d
@@ -461,6 +637,7 @@ entropy_buffer_capacity_kb %d
 `,
 			bytes,
 			reseeds,
+			atomic.LoadUint64(&rngReseedFailures),
 			age,
 			bufBytes,
 			bufCap,
@@ -469,43 +646,340 @@ entropy_buffer_capacity_kb %d
 			entropyA,
 			entropyB,
 		)
+
+		fmt.Fprintf(w, `
+# HELP rng_reseed_counter Generate requests since last reseed, by the busiest shard
+# TYPE rng_reseed_counter gauge
+rng_reseed_counter %d
+
+# HELP rng_max_generates_per_reseed Generate requests allowed between reseeds, per shard
+# TYPE rng_max_generates_per_reseed gauge
+rng_max_generates_per_reseed %d
+
+# HELP rng_bytes_since_reseed Bytes generated since last reseed, by the busiest shard
+# TYPE rng_bytes_since_reseed gauge
+rng_bytes_since_reseed %d
+
+# HELP rng_max_bytes_per_reseed Bytes allowed between reseeds, per shard
+# TYPE rng_max_bytes_per_reseed gauge
+rng_max_bytes_per_reseed %d
+
+# HELP rng_forced_reseeds_total Reseeds forced by the generate or byte limit
+# TYPE rng_forced_reseeds_total counter
+rng_forced_reseeds_total %d
+
+# HELP rng_refused_requests_total Requests refused because a forced reseed failed
+# TYPE rng_refused_requests_total counter
+rng_refused_requests_total %d
+
+# HELP rng_drbg_shards Independently seeded master DRBG shards
+# TYPE rng_drbg_shards gauge
+rng_drbg_shards %d
+`,
+			metrics.GenerateCount,
+			metrics.MaxGenerates,
+			metrics.BytesSinceReseed,
+			metrics.MaxBytesPerReseed,
+			metrics.ForcedReseeds,
+			metrics.RefusedRequests,
+			metrics.Shards,
+		)
+
+		fmt.Fprintf(w, `
+# HELP rng_prediction_resistance_requests_total Requests reseeded from fresh QRNG entropy
+# TYPE rng_prediction_resistance_requests_total counter
+rng_prediction_resistance_requests_total %d
+
+# HELP rng_prediction_resistance_failures_total Requests answered 503 for lack of fresh entropy
+# TYPE rng_prediction_resistance_failures_total counter
+rng_prediction_resistance_failures_total %d
+`,
+			atomic.LoadUint64(&rngPRRequests),
+			atomic.LoadUint64(&rngPRFailures),
+		)
+
+		src := buf.Stats()
+		fmt.Fprintf(w, `
+# HELP qrng_source_bytes_read_total Bytes read from the QRNG source
+# TYPE qrng_source_bytes_read_total counter
+qrng_source_bytes_read_total %d
+
+# HELP qrng_source_read_errors_total Failed reads from the QRNG source
+# TYPE qrng_source_read_errors_total counter
+qrng_source_read_errors_total %d
+
+# HELP rng_health_rct_failures_total SP 800-90B repetition count test failures
+# TYPE rng_health_rct_failures_total counter
+rng_health_rct_failures_total %d
+
+# HELP rng_health_apt_failures_total SP 800-90B adaptive proportion test failures
+# TYPE rng_health_apt_failures_total counter
+rng_health_apt_failures_total %d
+
+# HELP rng_health_rejected_blocks_total Raw QRNG blocks dropped by a failing health test
+# TYPE rng_health_rejected_blocks_total counter
+rng_health_rejected_blocks_total %d
+`,
+			src.BytesRead,
+			src.ReadErrors,
+			src.RCTFailures,
+			src.APTFailures,
+			src.RejectedBlocks,
+		)
+
+		cs := cond.Stats()
+		fmt.Fprintf(w, `
+# HELP rng_conditioner_input_bytes_total Raw bytes consumed by the conditioning function
+# TYPE rng_conditioner_input_bytes_total counter
+rng_conditioner_input_bytes_total{function=%[1]q} %[2]d
+
+# HELP rng_conditioner_output_bytes_total Full-entropy bytes produced by the conditioning function
+# TYPE rng_conditioner_output_bytes_total counter
+rng_conditioner_output_bytes_total{function=%[1]q} %[3]d
+
+# HELP rng_conditioner_ratio Raw input bytes per conditioned output byte
+# TYPE rng_conditioner_ratio gauge
+rng_conditioner_ratio{function=%[1]q} %.4[4]f
+`,
+			cs.Function,
+			cs.InputBytes,
+			cs.OutputBytes,
+			cs.Ratio,
+		)
+
+		fmt.Fprintf(w, `
+# HELP rng_egd_connections_total Connections accepted by the EGD listeners
+# TYPE rng_egd_connections_total counter
+rng_egd_connections_total %d
+
+# HELP rng_egd_bytes_total Random bytes served over EGD
+# TYPE rng_egd_bytes_total counter
+rng_egd_bytes_total %d
+
+# HELP rng_egd_requests_total EGD commands received
+# TYPE rng_egd_requests_total counter
+`,
+			atomic.LoadUint64(&egdConnections),
+			atomic.LoadUint64(&egdBytes),
+		)
+		for i, name := range egdCommands {
+			fmt.Fprintf(w, "rng_egd_requests_total{command=%q} %d\n", name, atomic.LoadUint64(&egdRequests[i]))
+		}
+
+		if kf != nil {
+			ks := kf.Stats()
+			fmt.Fprintf(w, `
+# HELP rng_kernel_feed_dry_run Whether the kernel feeder only simulates its writes
+# TYPE rng_kernel_feed_dry_run gauge
+rng_kernel_feed_dry_run %d
+
+# HELP rng_kernel_feed_writes_total RNDADDENTROPY writes to the kernel pool
+# TYPE rng_kernel_feed_writes_total counter
+rng_kernel_feed_writes_total %d
+
+# HELP rng_kernel_feed_bytes_total Conditioned bytes written to the kernel pool
+# TYPE rng_kernel_feed_bytes_total counter
+rng_kernel_feed_bytes_total %d
+
+# HELP rng_kernel_feed_credited_bits_total Entropy bits credited to the kernel pool
+# TYPE rng_kernel_feed_credited_bits_total counter
+rng_kernel_feed_credited_bits_total %d
+
+# HELP rng_kernel_feed_errors_total Failed source reads or kernel writes of the feeder
+# TYPE rng_kernel_feed_errors_total counter
+rng_kernel_feed_errors_total %d
+
+# HELP rng_kernel_entropy_avail Kernel entropy_avail as last seen by the feeder
+# TYPE rng_kernel_entropy_avail gauge
+rng_kernel_entropy_avail %d
+
+# HELP rng_kernel_write_wakeup_threshold Kernel write_wakeup_threshold as last seen by the feeder
+# TYPE rng_kernel_write_wakeup_threshold gauge
+rng_kernel_write_wakeup_threshold %d
+`,
+				boolGauge(ks.DryRun),
+				ks.Writes,
+				ks.Bytes,
+				ks.CreditedBits,
+				ks.Errors,
+				ks.EntropyAvail,
+				ks.WakeupThreshold,
+			)
+		}
+
+		fmt.Fprintf(w, `
+# HELP rng_accumulator_reseeds_total Reseeds drawn from the entropy pools
+# TYPE rng_accumulator_reseeds_total counter
+rng_accumulator_reseeds_total %d
+`, acc.Reseeds())
+
+		sources := acc.Sources()
+		fmt.Fprint(w, `
+# HELP rng_source_bytes_total Entropy bytes contributed to the pools per source
+# TYPE rng_source_bytes_total counter
+`)
+		for _, s := range sources {
+			fmt.Fprintf(w, "rng_source_bytes_total{source=%q} %d\n", s.Name, s.Bytes)
+		}
+		fmt.Fprint(w, `
+# HELP rng_source_events_total Events added to the pools per source
+# TYPE rng_source_events_total counter
+`)
+		for _, s := range sources {
+			fmt.Fprintf(w, "rng_source_events_total{source=%q} %d\n", s.Name, s.Events)
+		}
+		fmt.Fprint(w, `
+# HELP rng_source_errors_total Failed reads per source
+# TYPE rng_source_errors_total counter
+`)
+		for _, s := range sources {
+			fmt.Fprintf(w, "rng_source_errors_total{source=%q} %d\n", s.Name, s.Errors)
+		}
+
+		failover := fo.Status()
+		fmt.Fprint(w, `
+# HELP rng_failover_source_active Source currently feeding the QRNG buffer
+# TYPE rng_failover_source_active gauge
+`)
+		for _, s := range failover {
+			fmt.Fprintf(w, "rng_failover_source_active{source=%q} %d\n", s.Name, boolGauge(s.Active))
+		}
+		fmt.Fprint(w, `
+# HELP rng_failover_source_quarantined Source taken out of rotation after a failure
+# TYPE rng_failover_source_quarantined gauge
+`)
+		for _, s := range failover {
+			fmt.Fprintf(w, "rng_failover_source_quarantined{source=%q} %d\n", s.Name, boolGauge(s.Quarantined))
+		}
+		fmt.Fprint(w, `
+# HELP rng_failover_source_failures_total Failed reads or checks per source
+# TYPE rng_failover_source_failures_total counter
+`)
+		for _, s := range failover {
+			fmt.Fprintf(w, "rng_failover_source_failures_total{source=%q} %d\n", s.Name, s.Failures)
+		}
+
+		estimates := sourceEstimates(buf, acc)
+		fmt.Fprint(w, `
+# HELP rng_source_min_entropy Online SP 800-90B min-entropy estimate per source, bits per byte
+# TYPE rng_source_min_entropy gauge
+`)
+		for _, e := range estimates {
+			fmt.Fprintf(w, "rng_source_min_entropy{source=%q} %.4f\n", e.name, e.MinEntropy)
+		}
+		fmt.Fprint(w, `
+# HELP rng_source_min_entropy_estimate Individual SP 800-90B estimators per source, bits per byte
+# TYPE rng_source_min_entropy_estimate gauge
+`)
+		for _, e := range estimates {
+			for _, v := range []struct {
+				estimator string
+				value     float64
+			}{
+				{"mcv", e.MostCommon},
+				{"collision", e.Collision},
+				{"markov", e.Markov},
+				{"compression", e.Compression},
+			} {
+				fmt.Fprintf(w, "rng_source_min_entropy_estimate{source=%q,estimator=%q} %.4f\n", e.name, v.estimator, v.value)
+			}
+		}
+	}
+}
+
+// namedEstimate is the min-entropy estimate of one source
+type namedEstimate struct {
+	name string
+	rng.Estimate
+}
+
+// sourceEstimates returns the estimates of the sources behind the QRNG
+// buffer followed by the accumulator sources, each sorted by name
+func sourceEstimates(buf *rng.QRNGBuffer, acc *rng.Accumulator) []namedEstimate {
+	var out []namedEstimate
+	byName := buf.Estimates()
+	for _, name := range slices.Sorted(maps.Keys(byName)) {
+		out = append(out, namedEstimate{name, byName[name]})
+	}
+	for _, s := range acc.Sources() {
+		if s.Estimated {
+			out = append(out, namedEstimate{s.Name, s.Estimate})
+		}
+	}
+	return out
+}
+
+// boolGauge renders a boolean as a 0/1 gauge value
+func boolGauge(b bool) int {
+	if b {
+		return 1
 	}
+	return 0
 }
 
-func healthHandler(d *rng.DRBG) http.HandlerFunc {
+func healthHandler(g rng.Generator, buf *rng.QRNGBuffer, fo *rng.Failover, selfTests []rng.SelfTest, kf *rng.KernelFeeder) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		meta := d.GetMetadata()
+		meta := g.Metadata()
 
 		health := HealthInfo{
 			Status:               "ok",
 			Version:              meta.Version,
 			Source:               meta.Source,
 			DRBG:                 meta.DRBG,
-			ReseedAgeMs:          d.ReseedAge().Milliseconds(),
+			ReseedAgeMs:          meta.ReseedAge.Milliseconds(),
 			ReseedIntervalMs:     meta.ReseedIntervalMs,
 			ReseedSizeBits:       meta.ReseedSizeBits,
 			EntropyBufferedBytes: meta.EntropyBufferedBytes,
 			EntropyBufferedPCT:   meta.EntropyFillPct,
+			ReseedCounter:        meta.GenerateCount,
+			MaxGenerates:         meta.MaxGenerates,
+			BytesSinceReseed:     meta.BytesSinceReseed,
+			MaxBytesPerReseed:    meta.MaxBytesPerReseed,
+			ForcedReseeds:        meta.ForcedReseeds,
+			RefusedRequests:      meta.RefusedRequests,
+			Shards:               meta.Shards,
+		}
+		src := buf.Stats()
+		health.QRNGReadErrors = src.ReadErrors
+		health.QRNGLastError = src.LastError
+		health.QRNGSourceError = src.SourceError
+		health.ReseedError, _ = reseedError.Load().(string)
+		health.Sources = fo.Status()
+		health.SelfTests = selfTests
+		if kf != nil {
+			ks := kf.Stats()
+			health.KernelFeed = &ks
+		}
+		health.HealthTests = healthTests{
+			Status:         src.Health,
+			LastFailure:    src.HealthError,
+			MinEntropy:     src.MinEntropy,
+			RCTCutoff:      src.RCTCutoff,
+			APTCutoff:      src.APTCutoff,
+			RCTFailures:    src.RCTFailures,
+			APTFailures:    src.APTFailures,
+			RejectedBlocks: src.RejectedBlocks,
+		}
+		health.Status = worstStatus(sourceStatus(health.Sources), src.Health)
+		if health.QRNGSourceError != "" || health.ReseedError != "" {
+			health.Status = worstStatus(health.Status, "degraded")
 		}
 
 		// keep headers
-		d.WriteHeaders(w)
+		meta.WriteHeaders(w)
 
 		w.Header().Set("Content-Type", "application/json")
+		if health.Status == "failed" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
 		if err := json.NewEncoder(w).Encode(health); err != nil {
 			http.Error(w, "failed to encode health info", http.StatusInternalServerError)
 		}
 	}
 }
 
-var bufPool = sync.Pool{
-	New: func() any {
-		return make([]byte, 1<<20) // 1 MB
-	},
-}
-
-func startHTTP(ctx context.Context, addr string, handler http.Handler, master *rng.DRBG) (*http.Server, error) {
+func startHTTP(ctx context.Context, addr string, handler http.Handler, master rng.Generator) (*http.Server, error) {
 	//ln, err := net.Listen("tcp", addr)
 	//if err != nil { return nil, err }
 	//tln := newTunedListener(ln)
@@ -514,23 +988,12 @@ func startHTTP(ctx context.Context, addr string, handler http.Handler, master *r
 		return nil, err
 	}
 
+	conns := newConnGenerators(master)
 	srv := &http.Server{
-		Addr:    addr,
-		Handler: handler,
-		ConnContext: func(cctx context.Context, c net.Conn) context.Context {
-			//seed, _ := master.Derive(32)
-			//nonce, _ := master.Derive(12)
-
-			// derive per-connection DRBG from master
-			//childDRBG, _ := rng.NewDRBG(seed)
-			childDRBG, cerr := rng.NewConnectionDRBG(master) // (DRBG)
-			if cerr != nil {
-				return ctx
-			}
-
-			// attach to context for handlers
-			return context.WithValue(cctx, "conn_drbg", childDRBG)
-		},
+		Addr:        addr,
+		Handler:     handler,
+		ConnContext: conns.connContext,
+		ConnState:   conns.connState,
 	}
 
 	// Serve loop
@@ -551,12 +1014,13 @@ func startHTTP(ctx context.Context, addr string, handler http.Handler, master *r
 		defer cancel()
 
 		_ = srv.Shutdown(shutdownCtx)
+		conns.zeroizeAll()
 	}()
 
 	return srv, nil
 }
 
-func startHTTPS(ctx context.Context, addr string, handler http.Handler, tlsConfig *tls.Config, master *rng.DRBG) (*http.Server, error) {
+func startHTTPS(ctx context.Context, addr string, handler http.Handler, tlsConfig *tls.Config, master rng.Generator) (*http.Server, error) {
 	/*
 		if os.Getenv("TLS") == "1" {
 			tlsCfg := newTLSConfig("cert.pem", "key.pem")
@@ -574,19 +1038,13 @@ func startHTTPS(ctx context.Context, addr string, handler http.Handler, tlsConfi
 	tlsConfig.Certificates = []tls.Certificate{cert}
 	tlsLn := tls.NewListener(ln, tlsConfig)
 
+	conns := newConnGenerators(master)
 	srv := &http.Server{
-		Addr:      addr,
-		Handler:   handler,
-		TLSConfig: tlsConfig,
-		ConnContext: func(cctx context.Context, c net.Conn) context.Context {
-			// derive per-connection DRBG from master
-			seed, _ := master.Derive(32)
-			//nonce, _ := master.Derive(12)
-			childDRBG, _ := rng.NewDRBG(seed)
-			//childDRBG, cerr := rng.NewConnectionDRBG(master) // (DRBG)
-			// attach to context for handlers
-			return context.WithValue(cctx, "conn_drbg", childDRBG)
-		},
+		Addr:        addr,
+		Handler:     handler,
+		TLSConfig:   tlsConfig,
+		ConnContext: conns.connContext,
+		ConnState:   conns.connState,
 	}
 
 	// remove below comment to enable HTTP/2
@@ -617,11 +1075,50 @@ func startHTTPS(ctx context.Context, addr string, handler http.Handler, tlsConfi
 		defer cancel()
 
 		_ = srv.Shutdown(shutdownCtx)
+		conns.zeroizeAll()
 	}()
 
 	return srv, nil
 }
 
+// netSourceConfig returns the transport of the network entropy sources
+func netSourceConfig() rng.NetSourceConfig {
+	cfg := rng.NetSourceConfig{
+		Timeout:    time.Duration(envUint("RNG_NET_TIMEOUT_MS", 5000)) * time.Millisecond,
+		MaxRequest: int(envUint("RNG_NET_MAX_REQUEST", rng.DefaultNetMaxRequest)),
+	}
+	pins, err := rng.ParsePins(os.Getenv("RNG_NET_TLS_PINS"))
+	if err != nil {
+		log.Fatalf("invalid RNG_NET_TLS_PINS: %v", err)
+	}
+	cfg.Pins = pins
+	if ca := os.Getenv("RNG_NET_TLS_CA"); ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			log.Fatalf("invalid RNG_NET_TLS_CA: %v", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			log.Fatalf("invalid RNG_NET_TLS_CA: no certificate in %s", ca)
+		}
+	}
+	return cfg
+}
+
+// openSource returns the network or built-in source named by spec, ok is
+// false when spec is a device path
+func openSource(spec string, netCfg rng.NetSourceConfig) (src rng.QRNG, ok bool, err error) {
+	if rng.IsNetSource(spec) {
+		ns, err := rng.NewNetSource(spec, netCfg)
+		if err != nil {
+			return nil, true, err
+		}
+		netSources = append(netSources, ns)
+		return ns, true, nil
+	}
+	return rng.BuiltinSource(spec)
+}
+
 func main() {
 
 	// Root context canceled on signal
@@ -632,28 +1129,155 @@ func main() {
 	)
 	defer stop()
 
-	// Initialize QRNG buffer
-	qrngBuf := rng.NewQRNGBuffer("/dev/qrandom0", 2*1024*1024)
+	// Power-on self-tests: the known-answer test of every registered DRBG,
+	// then the SP 800-90B startup health test of every entropy source below.
+	// Nothing is served unless they pass.
+	selfTests := rng.RunKATs()
+	for _, t := range selfTests {
+		if !t.Passed {
+			log.Fatalf("self-test %s %s failed: %s", t.Kind, t.Name, t.Error)
+		}
+		log.Printf("self-test %s %s passed", t.Kind, t.Name)
+	}
+	minEntropy := envFloat("RNG_MIN_ENTROPY", rng.DefaultMinEntropy)
+
+	// Seed saved by the previous run, mixed into the initial seeds so a cold
+	// start does not depend on the sources alone. Loading consumes it, a new
+	// one is written as soon as the DRBG is up.
+	var seedFile *rng.SeedFile
+	var savedSeed []byte
+	if path := envString("RNG_SEED_FILE", ""); path != "" {
+		seedFile = rng.NewSeedFile(path, rng.DefaultSeedFileSize)
+		var serr error
+		savedSeed, serr = seedFile.Load()
+		switch {
+		case serr == nil:
+			log.Printf("seed file %s loaded", path)
+		case errors.Is(serr, os.ErrNotExist):
+			log.Printf("seed file %s not found, starting from the sources only", path)
+		default:
+			log.Printf("seed file %s not used: %v", path, serr)
+		}
+	}
 
-	// Initialize seed space (in bytes here)
-	seed, serr := fetchEntropy(64) // 64*8 = 512 bits
-	if serr != nil {
-		log.Fatal(serr)
+	// Initialize QRNG buffer
+	// Ordered entropy sources, the Quantis card first and RNG_FALLBACK_SOURCES
+	// after it. A failing source is quarantined and the next one takes over,
+	// quarantined sources are probed in the background.
+	failover := rng.NewFailover(time.Duration(envUint("RNG_QUARANTINE_MS", 30000))*time.Millisecond, 5*time.Second)
+	failover.SetMinEntropy(minEntropy)
+	primary := envString("RNG_QRNG_NAME", "QRNG-idQuantique-QuantisPCI")
+	sources := append([][2]string{{primary, envString("RNG_QRNG_DEVICE", "/dev/qrandom0")}},
+		envPairs("RNG_FALLBACK_SOURCES")...)
+
+	netCfg := netSourceConfig()
+	var devices []*rng.QRNGCard
+	passed := 0
+	for _, src := range sources {
+		// built-in (getrandom, rdseed, rdrand) and network sources take the
+		// place of a path
+		dev, builtin, err := openSource(src[1], netCfg)
+		if err != nil {
+			log.Fatalf("entropy source %s: %v", src[0], err)
+		}
+		if !builtin {
+			card := rng.NewQRNGDevice(src[1])
+			if info, ierr := card.Info(); ierr == nil {
+				log.Printf("Quantis card %s: driver %#x, board %#x, pci %#x, modules %#x/%#x", src[1],
+					info.DriverVersion, info.BoardVersion, info.BusDeviceID, info.ModulesStatus, info.ModulesMask)
+			}
+			devices = append(devices, card)
+			dev = card
+		}
+		failover.Add(src[0], dev)
+
+		// a source failing its startup test starts out quarantined
+		t := rng.StartupHealthTest(src[0], dev, minEntropy)
+		selfTests = append(selfTests, t)
+		if !t.Passed {
+			log.Printf("self-test %s %s (%s) failed: %s", t.Kind, t.Name, src[1], t.Error)
+			failover.Quarantine(src[0], fmt.Errorf("startup health test: %s", t.Error))
+			continue
+		}
+		log.Printf("self-test %s %s (%s) passed", t.Kind, t.Name, src[1])
+		passed++
+	}
+	if passed == 0 {
+		if savedSeed == nil {
+			log.Fatal("no entropy source passed the startup health test")
+		}
+		log.Println("no entropy source passed the startup health test, starting from the seed file")
 	}
-	//nonce, nerr := fetchEntropy(12) // 12*8 = 96 bits
-	//if nerr != nil { log.Fatal(nerr) }
 
-	// Initialize DRBG. Note that multiple instances of DRBG are created on a per-connection basis
-	drbg, derr := rng.NewDRBG(seed)
+	// Buffer the active source (2MB for testing purposes)
+	qrngBuf := rng.NewQRNGBuffer(failover, 2*1024*1024)
+	// SP 800-90B health test cutoffs derive from the claimed min-entropy per byte
+	qrngBuf.SetMinEntropy(minEntropy)
+	// Refill below the low watermark, up to the high one (percent of capacity)
+	qrngBuf.SetWatermarks(
+		int(envUint("RNG_BUFFER_LOW_PCT", 50))*2*1024*1024/100,
+		int(envUint("RNG_BUFFER_HIGH_PCT", 100))*2*1024*1024/100,
+	)
+	qrngBuffer = qrngBuf
+	atomic.AddUint64(&rngBytesBuffered, 2*1024*1024)
+
+	// Bits of entropy each reseed claims to deliver, the accumulator scales
+	// the raw input it waits for by the estimated min-entropy of the sources
+	reseedBits := int(envUint("RNG_RESEED_BITS", 256))
+
+	// Conditioning stage between the buffer and the DRBGs: seeds, forced
+	// reseeds, prediction resistance and /v1/conditioned draw full-entropy
+	// output of a vetted function, see rng.ConditioningFunctions()
+	condFn, cerr := rng.NewConditioningFunction(envString("RNG_CONDITIONER", rng.CondHMACSHA256))
+	if cerr != nil {
+		log.Fatal(cerr)
+	}
+	cond := rng.NewConditioner(qrngBuf, condFn, minEntropy)
+	cond.SetMinRatio(envFloat("RNG_CONDITIONING_MIN_RATIO", 1))
+	conditioner = cond
+
+	// DRBG algorithm by registry name, see rng.Algorithms()
+	algo := envString("RNG_DRBG", rng.AlgoChaCha20)
+
+	// Initialize the master DRBG: one independently seeded shard per P so
+	// requests do not serialize on a single mutex (64 bytes of QRNG entropy
+	// each). Per-connection DRBGs are derived from it.
+	shards := int(envUint("RNG_SHARDS", uint64(runtime.GOMAXPROCS(0))))
+	var fresh []byte
+	if savedSeed != nil {
+		fresh = startupEntropy(ctx, 64)
+	}
+	shard := 0
+	drbg, derr := rng.NewPool(algo, shards, func(n int) ([]byte, error) {
+		defer func() { shard++ }()
+		return initialSeed(ctx, n, savedSeed, fresh, shard)
+	})
+	clear(savedSeed)
+	clear(fresh)
 	if derr != nil {
 		log.Fatal(derr)
 	}
 
-	// SetMetadata(version, source, drbg-algo, reseed-interval, reseed-size, buffer-source)
-	drbg.SetMetadata("1.0.0", "QRNG-idQuantique-QuantisPCI", "ChaCha20", 2000*time.Millisecond, 256, qrngBuf)
+	// Replace the consumed seed file right away, then refresh it periodically
+	// and on shutdown
+	if seedFile != nil {
+		saveSeed(seedFile, drbg)
+		go seedFileLoop(ctx, seedFile, drbg,
+			time.Duration(envUint("RNG_SEED_FILE_INTERVAL_MS", 600000))*time.Millisecond)
+	}
+
+	// SetMetadata(version, source, reseed-interval, reseed-size, buffer-source)
+	// The source reported in headers follows the failover, primary is the fallback name
+	drbg.SetMetadata("1.0.0", primary, 2000*time.Millisecond, reseedBits, qrngBuf)
+
+	// Per-key limits, the DRBG reseeds from the buffer (or refuses output) when reached
+	maxGenerates := envUint("RNG_MAX_GENERATES", rng.DefaultMaxGenerates)
+	maxBytes := envUint("RNG_MAX_BYTES_PER_RESEED", rng.DefaultMaxBytesPerReseed)
+	drbg.SetLimits(maxGenerates, maxBytes)
 
 	// Attach the QRNG buffer for dynamic header reporting
 	drbg.SetEntropyBuffer(qrngBuf)
+	drbg.SetConditioner(cond)
 
 	//tln := newTunedListener(ln)
 
@@ -664,28 +1288,83 @@ func main() {
 	}
 	tlsCfg.Certificates = []tls.Certificate{cert}
 
-	masterDRBG, _ := rng.NewDRBG(seed)
-
 	// create the multiplexed listener proto
 	mux := http.NewServeMux()
 
 	// Run permanent reseed loop
-	go reseedLoop(ctx, drbg)
+	// Entropy accumulator: the QRNG buffer plus RNG_EXTRA_SOURCES feed the
+	// Fortuna pools the reseed loop draws from
+	acc := rng.NewAccumulator()
+	acc.SetReseedBits(reseedBits, minEntropy)
+	interval := time.Duration(envUint("RNG_SOURCE_INTERVAL_MS", 10)) * time.Millisecond
+	acc.AddSource("qrng", qrngBuf, interval)
+	var extra []*rng.QRNGCard
+	for _, src := range envPairs("RNG_EXTRA_SOURCES") {
+		dev, builtin, err := openSource(src[1], netCfg)
+		if !builtin {
+			var card *rng.QRNGCard
+			if card, err = rng.NewQRNGCard(src[1]); err == nil {
+				extra = append(extra, card)
+				dev = card
+			}
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// sources failing their startup test do not feed the pools
+		t := rng.StartupHealthTest(src[0], dev, minEntropy)
+		selfTests = append(selfTests, t)
+		if !t.Passed {
+			log.Printf("self-test %s %s (%s) failed, not used: %s", t.Kind, t.Name, src[1], t.Error)
+			continue
+		}
+		log.Printf("self-test %s %s (%s) passed", t.Kind, t.Name, src[1])
+		acc.AddSource(src[0], dev, interval)
+	}
+
+	go reseedLoop(ctx, drbg, acc)
+
+	// Kernel pool feeder (rngd replacement), RNG_KERNEL_FEED=1 credits
+	// conditioned output to /dev/random. The dry run needs no privileges.
+	var feeder *rng.KernelFeeder
+	if envBool("RNG_KERNEL_FEED", false) {
+		dryRun := envBool("RNG_KERNEL_FEED_DRY_RUN", false)
+		kf, kerr := rng.NewKernelFeeder(cond, dryRun)
+		if kerr != nil {
+			log.Fatalf("kernel feeder: %v", kerr)
+		}
+		kf.SetCredit(envFloat("RNG_KERNEL_FEED_CREDIT", rng.DefaultKernelFeedCredit))
+		kf.SetMaxBytes(int(envUint("RNG_KERNEL_FEED_MAX_BYTES", rng.DefaultKernelFeedMaxBytes)))
+		kf.SetIntervals(time.Duration(envUint("RNG_KERNEL_FEED_POLL_MS", 1000))*time.Millisecond,
+			time.Duration(envUint("RNG_KERNEL_FEED_REFRESH_MS", 60000))*time.Millisecond)
+		kf.Start()
+		feeder = kf
+		log.Printf("kernel feeder started (dry run: %v)", dryRun)
+	}
+
+	// Prediction resistance, RNG_PREDICTION_RESISTANCE=1 turns it on for every request
+	pr := &predictionResistance{
+		src:     cond,
+		always:  envBool("RNG_PREDICTION_RESISTANCE", false),
+		timeout: time.Duration(envUint("RNG_PR_TIMEOUT_MS", 500)) * time.Millisecond,
+	}
 
-	mux.HandleFunc("/v1/random", randomBytesHandler(drbg)) // now reads DRBG from context
+	mux.HandleFunc("/v1/random", randomBytesHandler(drbg, pr)) // now reads DRBG from context
+	mux.HandleFunc("/v1/conditioned", conditionedHandler(cond))
 	mux.HandleFunc("/v1/test", randomHandler(drbg))
 	mux.HandleFunc("/v1/image/random", randomImageHandler(drbg))
 	mux.HandleFunc("/v1/image/heatmap", entropyHeatmapHandler(drbg))
-	mux.HandleFunc("/health", healthHandler(drbg))
-	mux.Handle("/metrics", metricsHandler(drbg))
+	mux.HandleFunc("/health", healthHandler(drbg, qrngBuf, failover, selfTests, feeder))
+	mux.Handle("/metrics", metricsHandler(drbg, qrngBuf, acc, failover, cond, feeder))
 
 	// start HTTP & HTTPS servers on the same mux
-	httpSrv, httpErr := startHTTP(ctx, ":8080", mux, masterDRBG)
+	httpSrv, httpErr := startHTTP(ctx, ":8080", mux, drbg)
 	if httpErr != nil {
 		log.Fatal(httpErr)
 	}
 
-	httpsSrv, httpsErr := startHTTPS(ctx, ":8443", mux, tlsCfg, masterDRBG)
+	httpsSrv, httpsErr := startHTTPS(ctx, ":8443", mux, tlsCfg, drbg)
 	if httpsErr != nil {
 		log.Fatal(httpsErr)
 	}
@@ -693,6 +1372,21 @@ func main() {
 	log.Println("HTTP server running on :8080")
 	log.Println("HTTPs server running on :8443")
 
+	// EGD protocol for legacy clients, on a Unix socket and/or TCP
+	var egdServers []*egdServer
+	egdIdle := time.Duration(envUint("RNG_EGD_IDLE_MS", 300000)) * time.Millisecond
+	for _, l := range [][2]string{{"unix", os.Getenv("RNG_EGD_SOCKET")}, {"tcp", os.Getenv("RNG_EGD_ADDR")}} {
+		if l[1] == "" {
+			continue
+		}
+		egd, err := startEGD(ctx, l[0], l[1], drbg, qrngBuf, cond, egdIdle)
+		if err != nil {
+			log.Fatalf("EGD listener: %v", err)
+		}
+		egdServers = append(egdServers, egd)
+		log.Printf("EGD server running on %s %s", l[0], egd.Addr())
+	}
+
 	<-ctx.Done()
 	log.Println("shutdown signal received")
 
@@ -701,12 +1395,38 @@ func main() {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if httpErr != nil {
+	if httpSrv != nil {
 		_ = httpSrv.Shutdown(shutdownCtx)
 	}
 	if httpsSrv != nil {
 		_ = httpsSrv.Shutdown(shutdownCtx)
 	}
+	for _, egd := range egdServers {
+		egd.Close()
+	}
+
+	// No handler is running anymore, save a seed for the next start, then
+	// wipe the master key material and whatever entropy is still buffered
+	if seedFile != nil {
+		saveSeed(seedFile, drbg)
+	}
+	if feeder != nil {
+		feeder.Stop()
+	}
+	drbg.Zeroize()
+	acc.Zeroize()
+	cond.Zeroize()
+	qrngBuf.Zeroize()
+	failover.Stop()
+	for _, dev := range devices {
+		dev.Close()
+	}
+	for _, dev := range extra {
+		dev.Close()
+	}
+	for _, src := range netSources {
+		src.Close()
+	}
 
 	log.Println("shutdown complete")
 
@@ -7,10 +7,16 @@ import (
 var (
 	rngBytesGenerated uint64
 	rngReseeds        uint64
+	rngReseedFailures uint64
 	rngBytesBuffered  uint64
 	rngBytesTestA     uint64
 	rngBytesTestB     uint64
 	httpRequests      uint64
+	rngPRRequests     uint64
+	rngPRFailures     uint64
+	egdConnections    uint64
+	egdRequests       [len(egdCommands)]uint64 // per EGD command
+	egdBytes          uint64
 )
 
 func incRNGBytes(n int) {
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"entropy-service/rng"
+)
+
+// stalledSource never delivers until closed
+type stalledSource chan struct{}
+
+func (s stalledSource) Read(p []byte) error {
+	<-s
+	return errors.New("closed")
+}
+
+// newPRTest returns a master, a connection generator derived from it and
+// prediction resistance reading src
+func newPRTest(t *testing.T, src rng.QRNG) (rng.Generator, rng.Generator, *predictionResistance) {
+	t.Helper()
+	master, err := rng.NewDRBGWithAlgo(rng.AlgoChaCha20, make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(master.Zeroize)
+	gen, err := rng.NewConnectionDRBG(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(gen.Zeroize)
+
+	buf := rng.NewQRNGBuffer(src, 4096)
+	t.Cleanup(buf.Stop)
+	fn, err := rng.NewConditioningFunction(rng.CondHMACSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return master, gen, &predictionResistance{
+		src:     rng.NewConditioner(buf, fn, 8),
+		timeout: 100 * time.Millisecond,
+	}
+}
+
+// serve runs one request on the connection of gen
+func serve(h http.HandlerFunc, gen rng.Generator, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, r.WithContext(rng.ContextWithGenerator(r.Context(), gen)))
+	return w
+}
+
+func TestPredictionResistanceReseeds(t *testing.T) {
+	master, gen, pr := newPRTest(t, rng.FromReader(rand.Reader))
+	h := randomBytesHandler(master, pr)
+
+	if w := serve(h, gen, httptest.NewRequest("GET", "/v1/random?bytes=100", nil)); w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if n := gen.Metadata().BytesSinceReseed; n != 100 {
+		t.Fatalf("%d bytes since reseed, want 100", n)
+	}
+
+	// the header asks for a reseed right before the 32 bytes
+	r := httptest.NewRequest("GET", "/v1/random?bytes=32", nil)
+	r.Header.Set("X-RNG-Prediction-Resistance", "1")
+	w := serve(h, gen, r)
+	if w.Code != http.StatusOK || w.Header().Get("X-RNG-Prediction-Resistance") != "1" {
+		t.Fatalf("status %d, prediction resistance %q", w.Code, w.Header().Get("X-RNG-Prediction-Resistance"))
+	}
+	if n := gen.Metadata().BytesSinceReseed; n != 32 {
+		t.Fatalf("%d bytes since reseed, want 32", n)
+	}
+
+	// the reseeded generator serves the next request on the connection
+	serve(h, gen, httptest.NewRequest("GET", "/v1/random?bytes=8", nil))
+	if n := gen.Metadata().BytesSinceReseed; n != 40 {
+		t.Fatalf("%d bytes since reseed, want 40", n)
+	}
+}
+
+func TestPredictionResistanceStarved(t *testing.T) {
+	src := make(stalledSource)
+	defer close(src)
+	master, gen, pr := newPRTest(t, src)
+	h := randomBytesHandler(master, pr)
+	failures := atomic.LoadUint64(&rngPRFailures)
+
+	start := time.Now()
+	w := serve(h, gen, httptest.NewRequest("GET", "/v1/random?pr=1", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if d := time.Since(start); d > 4*pr.timeout {
+		t.Errorf("answered after %v with a %v timeout", d, pr.timeout)
+	}
+	if atomic.LoadUint64(&rngPRFailures) != failures+1 {
+		t.Error("failure not counted")
+	}
+}
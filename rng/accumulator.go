@@ -0,0 +1,219 @@
+package rng
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"sync"
+	"time"
+)
+
+// Fortuna parameters (Ferguson & Schneier, Cryptography Engineering ch. 9)
+const (
+	fortunaPools       = 32
+	fortunaReseedBits  = 256 // estimated bits of entropy in pool 0 before a reseed
+	fortunaMinInterval = 100 * time.Millisecond
+	fortunaEventSize   = 32 // bytes pulled from a source per event
+)
+
+// Accumulator collects events from several entropy sources into 32 pools
+// and hands out reseed material Fortuna-style: reseed r uses pool i only
+// when 2^i divides r, so higher pools build up entropy a compromised or
+// stalled source cannot keep track of, and no single source decides when
+// reseeds happen.
+type Accumulator struct {
+	mu         sync.Mutex
+	pools      [fortunaPools]hash.Hash
+	pool0Bits  float64 // entropy credited to pool 0 since the last reseed
+	reseedBits float64
+	minEntropy float64 // claimed bits per byte, the most an event is credited
+	reseeds    uint64
+	lastReseed time.Time
+	sources    []*accSource
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// accSource is one registered source and its contribution so far
+type accSource struct {
+	id       byte
+	name     string
+	src      QRNG
+	interval time.Duration
+	pool     int // next pool to receive an event, round-robin
+	est      *EntropyEstimator
+
+	events uint64
+	bytes  uint64
+	errors uint64
+}
+
+// SourceStats reports the contribution of one accumulator source
+type SourceStats struct {
+	Name   string
+	Events uint64 // events added to the pools
+	Bytes  uint64 // bytes of entropy contributed
+	Errors uint64 // failed reads
+
+	Estimate  Estimate // online min-entropy estimate of the events
+	Estimated bool     // false until a full estimate window was sampled
+}
+
+// NewAccumulator returns an accumulator without sources
+func NewAccumulator() *Accumulator {
+	a := &Accumulator{
+		reseedBits: fortunaReseedBits,
+		minEntropy: DefaultMinEntropy,
+		stop:       make(chan struct{}),
+	}
+	for i := range a.pools {
+		a.pools[i] = sha256.New()
+	}
+	return a
+}
+
+// SetReseedBits sets how many bits of entropy pool 0 must be credited with
+// before a reseed, and the claimed min-entropy per byte of the sources. An
+// event is credited at the lower of the claim and the source's online
+// estimate, so a source that turns out weaker than claimed has to deliver
+// more raw input per reseed.
+func (a *Accumulator) SetReseedBits(bits int, minEntropy float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reseedBits = float64(bits)
+	if minEntropy > 0 && minEntropy <= 8 {
+		a.minEntropy = minEntropy
+	}
+}
+
+// AddSource starts pulling fortunaEventSize-byte events from src every
+// interval, spreading them over the pools
+func (a *Accumulator) AddSource(name string, src QRNG, interval time.Duration) {
+	a.mu.Lock()
+	s := &accSource{
+		id:       byte(len(a.sources)),
+		name:     name,
+		src:      src,
+		interval: interval,
+		est:      NewEntropyEstimator(),
+	}
+	a.sources = append(a.sources, s)
+	a.mu.Unlock()
+
+	go a.collect(s)
+}
+
+// collect is the event loop of one source
+func (a *Accumulator) collect(s *accSource) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	var event [fortunaEventSize]byte
+	defer clear(event[:])
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := s.src.Read(event[:])
+		if err == nil {
+			s.est.Sample(event[:])
+		}
+		a.mu.Lock()
+		select {
+		case <-a.stop:
+			a.mu.Unlock()
+			return
+		default:
+		}
+		if err != nil {
+			s.errors++
+		} else {
+			a.addEvent(s, event[:])
+		}
+		a.mu.Unlock()
+	}
+}
+
+// addEvent writes one event (source id, length, data) into the next pool of
+// s, a.mu must be held
+func (a *Accumulator) addEvent(s *accSource, data []byte) {
+	p := a.pools[s.pool]
+	p.Write([]byte{s.id, byte(len(data))})
+	p.Write(data)
+	if s.pool == 0 {
+		h := a.minEntropy
+		if e, ok := s.est.Estimate(); ok {
+			h = min(h, e.MinEntropy)
+		}
+		a.pool0Bits += float64(len(data)) * h
+	}
+	s.pool = (s.pool + 1) % fortunaPools
+	s.events++
+	s.bytes += uint64(len(data))
+}
+
+// Seed returns 64 bytes of reseed material when a reseed is due: pool 0 has
+// been credited with the reseed bits and the last reseed is at least 100ms old.
+// The pools used are emptied.
+func (a *Accumulator) Seed() ([]byte, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.pool0Bits < a.reseedBits || time.Since(a.lastReseed) < fortunaMinInterval {
+		return nil, false
+	}
+	a.reseeds++
+	a.lastReseed = time.Now()
+	a.pool0Bits = 0
+
+	h := sha512.New()
+	var sum [sha256.Size]byte
+	for i, p := range a.pools {
+		if i > 0 && a.reseeds%(1<<i) != 0 {
+			break
+		}
+		h.Write(p.Sum(sum[:0]))
+		p.Reset()
+	}
+	clear(sum[:])
+	return h.Sum(nil), true
+}
+
+// Reseeds returns the number of seeds handed out
+func (a *Accumulator) Reseeds() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reseeds
+}
+
+// Sources returns per-source contribution, in registration order
+func (a *Accumulator) Sources() []SourceStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	out := make([]SourceStats, len(a.sources))
+	for i, s := range a.sources {
+		out[i] = SourceStats{Name: s.name, Events: s.events, Bytes: s.bytes, Errors: s.errors}
+		out[i].Estimate, out[i].Estimated = s.est.Estimate()
+	}
+	return out
+}
+
+// Zeroize stops the collectors and resets every pool
+func (a *Accumulator) Zeroize() {
+	a.stopOnce.Do(func() { close(a.stop) })
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, p := range a.pools {
+		p.Reset()
+	}
+	for _, s := range a.sources {
+		s.est.Zeroize()
+	}
+	a.pool0Bits = 0
+}
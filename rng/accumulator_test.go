@@ -0,0 +1,109 @@
+package rng
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+// newTestSource returns a source registered with a but not collecting, its
+// events are added by the test
+func newTestSource(a *Accumulator) *accSource {
+	s := &accSource{name: "test", est: NewEntropyEstimator()}
+	a.sources = append(a.sources, s)
+	return s
+}
+
+// addEvents adds n events of fortunaEventSize bytes from s
+func addEvents(a *Accumulator, s *accSource, n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := 0; i < n; i++ {
+		a.addEvent(s, bytes.Repeat([]byte{byte(i)}, fortunaEventSize))
+	}
+}
+
+// seedNow asks for a seed as if the last one were long ago
+func seedNow(a *Accumulator) bool {
+	a.mu.Lock()
+	a.lastReseed = time.Time{}
+	a.mu.Unlock()
+	_, ok := a.Seed()
+	return ok
+}
+
+// TestAccumulatorPoolSchedule checks that reseed r drains pool i exactly
+// when 2^i divides r
+func TestAccumulatorPoolSchedule(t *testing.T) {
+	a := NewAccumulator()
+	defer a.Zeroize()
+	a.SetReseedBits(fortunaReseedBits, 8)
+	s := newTestSource(a)
+	empty := sha256.New().Sum(nil)
+
+	for r := uint64(1); r <= 128; r++ {
+		// one event in every pool
+		addEvents(a, s, fortunaPools)
+		if !seedNow(a) {
+			t.Fatalf("reseed %d not due", r)
+		}
+		for i, p := range a.pools {
+			drained := bytes.Equal(p.Sum(nil), empty)
+			if want := r%(1<<i) == 0; drained != want {
+				t.Fatalf("reseed %d: pool %d drained %v, want %v", r, i, drained, want)
+			}
+		}
+	}
+	if n := a.Reseeds(); n != 128 {
+		t.Errorf("%d reseeds, want 128", n)
+	}
+}
+
+// TestAccumulatorReseedThreshold checks that a seed is only handed out once
+// pool 0 holds the reseed bits, and not twice within fortunaMinInterval
+func TestAccumulatorReseedThreshold(t *testing.T) {
+	a := NewAccumulator()
+	defer a.Zeroize()
+	// an event credits 32 bytes * 4 bits = 128 bits, pool 0 needs two
+	a.SetReseedBits(fortunaReseedBits, 4)
+	s := newTestSource(a)
+
+	// events to the other pools do not count
+	addEvents(a, s, fortunaPools)
+	if seedNow(a) {
+		t.Fatal("reseed with 128 bits in pool 0")
+	}
+	addEvents(a, s, fortunaPools)
+	if !seedNow(a) {
+		t.Fatal("no reseed with 256 bits in pool 0")
+	}
+
+	addEvents(a, s, 2*fortunaPools)
+	if _, ok := a.Seed(); ok {
+		t.Fatal("second reseed within the minimum interval")
+	}
+	if !seedNow(a) {
+		t.Fatal("no reseed once the interval passed")
+	}
+	if seedNow(a) {
+		t.Fatal("reseed with pool 0 emptied by the last one")
+	}
+}
+
+// TestAccumulatorWeakSource checks that events are credited at the online
+// estimate when it is below the claim: a constant source never reseeds
+func TestAccumulatorWeakSource(t *testing.T) {
+	a := NewAccumulator()
+	defer a.Zeroize()
+	s := newTestSource(a)
+	s.est.Sample(make([]byte, estimateWindow))
+	if e, ok := s.est.Estimate(); !ok || e.MinEntropy != 0 {
+		t.Fatalf("estimate %+v (%v) of a constant source", e, ok)
+	}
+
+	addEvents(a, s, 100*fortunaPools)
+	if seedNow(a) {
+		t.Fatal("reseed from a source estimated at 0 bits per byte")
+	}
+}
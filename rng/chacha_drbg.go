@@ -0,0 +1,122 @@
+package rng
+
+import (
+	"crypto/sha512"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+const (
+	chachaMinEntropy = 32      // 256-bit key
+	chachaMaxRequest = 1 << 30 // keystream chunk per Generate call, well below 2^32 blocks
+)
+
+// ChaChaDRBG is the original ChaCha20 keystream generator, keyed from
+// SHA-512 of the seed material. Not an SP 800-90A mechanism, kept as the
+// default for hosts without AES-NI.
+//
+// Every Generate call uses fast-key-erasure: block 0 of the keystream
+// becomes the next key and the output starts at block 1, so the key that
+// produced an output is gone once Generate returns (backtracking resistance).
+type ChaChaDRBG struct {
+	key          [32]byte
+	nonce        [12]byte // 96-bit big-endian counter, incremented per Generate
+	instantiated bool
+}
+
+// NewChaChaDRBG returns an uninstantiated ChaCha20 DRBG
+func NewChaChaDRBG() *ChaChaDRBG {
+	return &ChaChaDRBG{}
+}
+
+// Instantiate keys the generator from SHA-512(entropy || nonce || personalization)
+func (c *ChaChaDRBG) Instantiate(entropy, nonce, personalization []byte) error {
+	if len(entropy) < chachaMinEntropy {
+		return ErrEntropyLength
+	}
+
+	h := sha512.New()
+	h.Write(entropy)
+	h.Write(nonce)
+	h.Write(personalization)
+	c.rekey(h.Sum(nil))
+	return nil
+}
+
+// Reseed rekeys the generator from SHA-512(key || entropy || additional)
+func (c *ChaChaDRBG) Reseed(entropy, additional []byte) error {
+	if !c.instantiated {
+		return ErrNotInstantiated
+	}
+	c.mix(entropy, additional)
+	return nil
+}
+
+// Generate fills out with keystream, mixing additional input into the key
+// first, then replaces the key with keystream block 0
+func (c *ChaChaDRBG) Generate(out, additional []byte) error {
+	if !c.instantiated {
+		return ErrNotInstantiated
+	}
+	if len(out) > chachaMaxRequest {
+		return ErrRequestTooLarge
+	}
+	if len(additional) > 0 {
+		c.mix(additional)
+	}
+
+	s, err := chacha20.NewUnauthenticatedCipher(c.key[:], c.nonce[:])
+	if err != nil {
+		return err
+	}
+
+	// block 0: next key
+	var block [64]byte
+	s.SetCounter(0)
+	s.XORKeyStream(block[:], block[:])
+	copy(c.key[:], block[:32])
+	clear(block[:])
+
+	// blocks 1..n: output
+	s.SetCounter(1)
+	clear(out)
+	s.XORKeyStream(out, out)
+
+	*s = chacha20.Cipher{}
+	chachaIncrement(&c.nonce)
+	return nil
+}
+
+// Zeroize wipes the key material and leaves the DRBG uninstantiated
+func (c *ChaChaDRBG) Zeroize() {
+	clear(c.key[:])
+	clear(c.nonce[:])
+	c.instantiated = false
+}
+
+func (c *ChaChaDRBG) mix(input ...[]byte) {
+	h := sha512.New()
+	h.Write(c.key[:])
+	for _, in := range input {
+		h.Write(in)
+	}
+	c.rekey(h.Sum(nil))
+}
+
+// rekey takes the key and initial nonce from a SHA-512 digest
+func (c *ChaChaDRBG) rekey(h []byte) {
+	copy(c.key[:], h[:32])
+	copy(c.nonce[:], h[32:44])
+	clear(h)
+	c.instantiated = true
+}
+
+// chachaIncrement adds one to the 96-bit nonce, modulo 2^96
+func chachaIncrement(n *[12]byte) {
+	for i := len(n) - 1; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			return
+		}
+	}
+}
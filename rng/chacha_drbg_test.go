@@ -0,0 +1,101 @@
+package rng
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// chachaBlocks returns n bytes of ChaCha20 keystream from block counter on
+func chachaBlocks(t *testing.T, key [32]byte, nonce [12]byte, counter uint32, n int) []byte {
+	t.Helper()
+	s, err := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetCounter(counter)
+	b := make([]byte, n)
+	s.XORKeyStream(b, b)
+	return b
+}
+
+func TestChaChaDRBGVector(t *testing.T) {
+	if err := chachaKAT.run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestChaChaDRBGBacktracking copies the whole state after Generate, nonce
+// included, and checks that neither the previous output nor the key that
+// produced it can be recomputed from it
+func TestChaChaDRBGBacktracking(t *testing.T) {
+	c := NewChaChaDRBG()
+	defer c.Zeroize()
+	if err := c.Instantiate(unhex(chachaKAT.entropy), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	prevKey, prevNonce := c.key, c.nonce
+	out := make([]byte, 256)
+	if err := c.Generate(out, nil); err != nil {
+		t.Fatal(err)
+	}
+	state := *c
+
+	// the harness itself can recompute out given the erased key
+	if !bytes.Equal(chachaBlocks(t, prevKey, prevNonce, 1, len(out)), out) {
+		t.Fatal("output is not keystream blocks 1.. of the previous key")
+	}
+	if !bytes.Equal(chachaBlocks(t, prevKey, prevNonce, 0, 32), state.key[:]) {
+		t.Fatal("next key is not keystream block 0 of the previous key")
+	}
+
+	// the copied nonce is one past the one used, so the attacker knows that
+	// one too; only the key is missing
+	nonce := state.nonce
+	for i := len(nonce) - 1; i >= 0; i-- {
+		nonce[i]--
+		if nonce[i] != 0xff {
+			break
+		}
+	}
+	if nonce != prevNonce {
+		t.Fatalf("nonce %x does not follow %x", state.nonce, prevNonce)
+	}
+	if state.key == prevKey || bytes.Contains(out, state.key[:]) {
+		t.Fatal("key not erased, or the next key is part of the output")
+	}
+
+	// every way to run the cipher on the copied state misses the output
+	for _, n := range [][12]byte{prevNonce, state.nonce} {
+		for counter := uint32(0); counter < 8; counter++ {
+			guess := chachaBlocks(t, state.key, n, counter, len(out)+32)
+			if bytes.Contains(guess, out[:32]) {
+				t.Fatalf("previous output recomputed at nonce %x block %d", n, counter)
+			}
+			if bytes.Contains(guess, prevKey[:]) {
+				t.Fatalf("previous key recomputed at nonce %x block %d", n, counter)
+			}
+		}
+	}
+
+	// nor does generating from it, forward or with the nonce rewound
+	for _, n := range [][12]byte{prevNonce, state.nonce} {
+		replay := state
+		replay.nonce = n
+		got := make([]byte, len(out))
+		if err := replay.Generate(got, nil); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(got, out) || replay.key == prevKey {
+			t.Fatalf("previous output or key recomputed from the state at nonce %x", n)
+		}
+	}
+}
+
+func TestChaChaDRBGSelfTest(t *testing.T) {
+	if err := chachaSelfTest(); err != nil {
+		t.Fatal(err)
+	}
+}
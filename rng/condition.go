@@ -0,0 +1,263 @@
+package rng
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha3"
+	"fmt"
+	"hash"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Vetted conditioning functions (SP 800-90B 3.1.5.1.1)
+const (
+	CondHMACSHA256   = "hmac-sha256"
+	CondCBCMACAES256 = "cbcmac-aes256"
+	CondSHA3256      = "sha3-256"
+)
+
+// fullEntropyMargin is the entropy in excess of the output length an input
+// block must carry for full-entropy output (SP 800-90C 4.3)
+const fullEntropyMargin = 64
+
+// ConditioningFunction compresses raw source bytes into OutputLen bytes
+type ConditioningFunction interface {
+	Name() string
+	OutputLen() int
+	Condition(out, in []byte) // out has OutputLen bytes
+}
+
+// ConditioningFunctions returns the supported function names
+func ConditioningFunctions() []string {
+	return []string{CondCBCMACAES256, CondHMACSHA256, CondSHA3256}
+}
+
+// NewConditioningFunction returns the named function, ignoring case. Keys of
+// the keyed functions are fixed per function, 90B does not require them to
+// be secret.
+func NewConditioningFunction(name string) (ConditioningFunction, error) {
+	key := sha256.Sum256([]byte("entropy-service conditioning " + strings.ToLower(name)))
+	switch strings.ToLower(name) {
+	case CondHMACSHA256:
+		return &hmacConditioner{mac: hmac.New(sha256.New, key[:])}, nil
+	case CondCBCMACAES256:
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, err
+		}
+		return &cbcMACConditioner{block: block}, nil
+	case CondSHA3256:
+		return sha3Conditioner{}, nil
+	}
+	return nil, fmt.Errorf("rng: unknown conditioning function %q (available: %s)",
+		name, strings.Join(ConditioningFunctions(), ", "))
+}
+
+// hmacConditioner reuses one keyed HMAC, Conditioner serializes the calls
+type hmacConditioner struct{ mac hash.Hash }
+
+func (*hmacConditioner) Name() string   { return CondHMACSHA256 }
+func (*hmacConditioner) OutputLen() int { return sha256.Size }
+
+func (c *hmacConditioner) Condition(out, in []byte) {
+	c.mac.Reset()
+	c.mac.Write(in)
+	c.mac.Sum(out[:0])
+}
+
+// cbcMACConditioner is CBC-MAC over AES-256, the input is zero padded to a
+// whole number of blocks. The input length follows the online estimate, so
+// it varies; with a public key CBC-MAC serves as a 90B conditioning
+// function here, not as a MAC, and length extension does not matter.
+type cbcMACConditioner struct{ block cipher.Block }
+
+func (*cbcMACConditioner) Name() string   { return CondCBCMACAES256 }
+func (*cbcMACConditioner) OutputLen() int { return aes.BlockSize }
+
+func (c *cbcMACConditioner) Condition(out, in []byte) {
+	var mac, next [aes.BlockSize]byte
+	for len(in) > 0 {
+		n := copy(next[:], in)
+		clear(next[n:])
+		in = in[n:]
+		for i := range mac {
+			mac[i] ^= next[i]
+		}
+		c.block.Encrypt(mac[:], mac[:])
+	}
+	copy(out, mac[:])
+	clear(mac[:])
+	clear(next[:])
+}
+
+type sha3Conditioner struct{}
+
+func (sha3Conditioner) Name() string   { return CondSHA3256 }
+func (sha3Conditioner) OutputLen() int { return 32 }
+
+func (sha3Conditioner) Condition(out, in []byte) {
+	sum := sha3.Sum256(in)
+	copy(out, sum[:])
+	clear(sum[:])
+}
+
+// Conditioner turns raw bytes from a QRNGBuffer into full-entropy output.
+// Each output block is conditioned from enough raw input to carry its length
+// plus 64 bits of entropy, at the lower of the claimed min-entropy and the
+// online estimate of the input, so the input/output ratio follows the source.
+type Conditioner struct {
+	mu       sync.Mutex
+	src      *QRNGBuffer
+	fn       ConditioningFunction
+	claimed  float64 // bits per byte
+	minRatio float64 // input/output ratio never goes below this
+	est      *EntropyEstimator
+	block    []byte // scratch output block
+
+	inBytes  uint64
+	outBytes uint64
+}
+
+// ConditionerStats reports a Conditioner's configuration and throughput
+type ConditionerStats struct {
+	Function    string
+	InputLen    int     // raw bytes per output block
+	OutputLen   int     // bytes per output block
+	Ratio       float64 // current input/output ratio
+	MinEntropy  float64 // bits per byte the ratio is computed from
+	InputBytes  uint64
+	OutputBytes uint64
+}
+
+// NewConditioner conditions raw bytes of src with fn, for a source claiming
+// h bits of min-entropy per byte
+func NewConditioner(src *QRNGBuffer, fn ConditioningFunction, h float64) *Conditioner {
+	if h <= 0 || h > 8 {
+		h = DefaultMinEntropy
+	}
+	return &Conditioner{
+		src:      src,
+		fn:       fn,
+		claimed:  h,
+		minRatio: 1,
+		est:      NewEntropyEstimator(),
+		block:    make([]byte, fn.OutputLen()),
+	}
+}
+
+// SetMinRatio sets a floor on the input/output ratio, e.g. 2 to always
+// condition at least twice as many raw bytes as are returned
+func (c *Conditioner) SetMinRatio(r float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.minRatio = max(r, 1)
+}
+
+// minEntropy returns the bits per byte credited to raw input, c.mu held
+func (c *Conditioner) minEntropy() float64 {
+	h := c.claimed
+	if e, ok := c.est.Estimate(); ok {
+		h = min(h, e.MinEntropy)
+	}
+	return h
+}
+
+// inputLen returns the raw bytes conditioned per output block, c.mu held
+func (c *Conditioner) inputLen() int {
+	out := c.fn.OutputLen()
+	need := math.Ceil(float64(8*out+fullEntropyMargin) / c.minEntropy())
+	return int(max(need, math.Ceil(c.minRatio*float64(out))))
+}
+
+// ReadTimeout fills p with conditioned output, waiting at most timeout in
+// total for the raw input
+func (c *Conditioner) ReadTimeout(p []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.ReadContext(ctx, p)
+}
+
+// ReadContext fills p with conditioned output, waiting for raw input until
+// ctx is done, see QRNGBuffer.GetContext for the errors
+func (c *Conditioner) ReadContext(ctx context.Context, p []byte) error {
+	return c.read(p, func(raw []byte) error { return c.src.ReadContext(ctx, raw) })
+}
+
+// Read fills p with conditioned output, which makes a Conditioner a QRNG
+func (c *Conditioner) Read(p []byte) error {
+	return c.ReadTimeout(p, bufferReadTimeout)
+}
+
+// TryRead fills p only if the buffer holds all the raw input right away
+func (c *Conditioner) TryRead(p []byte) bool {
+	err := c.read(p, func(raw []byte) error {
+		if !c.src.TryRead(raw) {
+			return ErrTimeout
+		}
+		return nil
+	})
+	return err == nil
+}
+
+// read conditions p block by block. The raw input is fetched without c.mu,
+// so a reader waiting for the buffer does not hold up TryRead or Stats.
+func (c *Conditioner) read(p []byte, get func(raw []byte) error) error {
+	var raw []byte
+	defer func() { clear(raw) }()
+	for len(p) > 0 {
+		c.mu.Lock()
+		n := c.inputLen()
+		c.mu.Unlock()
+		if cap(raw) < n {
+			clear(raw)
+			raw = make([]byte, n)
+		}
+		raw = raw[:n]
+		if err := get(raw); err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		c.est.Sample(raw)
+		c.fn.Condition(c.block, raw)
+		c.inBytes += uint64(len(raw))
+		n = copy(p, c.block)
+		clear(c.block)
+		c.outBytes += uint64(n)
+		c.mu.Unlock()
+		clear(raw)
+		p = p[n:]
+	}
+	return nil
+}
+
+// Stats returns the current ratio and the bytes conditioned so far
+func (c *Conditioner) Stats() ConditionerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	in := c.inputLen()
+	return ConditionerStats{
+		Function:    c.fn.Name(),
+		InputLen:    in,
+		OutputLen:   c.fn.OutputLen(),
+		Ratio:       float64(in) / float64(c.fn.OutputLen()),
+		MinEntropy:  c.minEntropy(),
+		InputBytes:  c.inBytes,
+		OutputBytes: c.outBytes,
+	}
+}
+
+// Zeroize wipes the samples kept for estimation
+func (c *Conditioner) Zeroize() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.block)
+	c.est.Zeroize()
+}
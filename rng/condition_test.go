@@ -0,0 +1,91 @@
+package rng
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stallQRNG blocks every Read until it is closed
+type stallQRNG chan struct{}
+
+func (s stallQRNG) Read(p []byte) error {
+	<-s
+	return errors.New("closed")
+}
+
+// TestConditionerWaitDoesNotBlock checks that a reader waiting for raw input
+// holds up neither TryRead nor the timeout of another reader
+func TestConditionerWaitDoesNotBlock(t *testing.T) {
+	src := make(stallQRNG)
+	buf := NewQRNGBuffer(src, 4096)
+	defer buf.Stop()
+	defer close(src)
+	fn, err := NewConditioningFunction(CondHMACSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewConditioner(buf, fn, 8)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := make(chan error)
+	go func() { waiting <- c.ReadContext(ctx, make([]byte, 64)) }()
+	time.Sleep(20 * time.Millisecond)
+
+	tryDone := make(chan bool)
+	go func() { tryDone <- c.TryRead(make([]byte, 64)) }()
+	select {
+	case ok := <-tryDone:
+		if ok {
+			t.Error("TryRead succeeded on an empty buffer")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("TryRead blocked behind a waiting reader")
+	}
+
+	start := time.Now()
+	if err := c.ReadTimeout(make([]byte, 64), 50*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("ReadTimeout: got %v, want ErrTimeout", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("ReadTimeout(50ms) took %v", d)
+	}
+
+	cancel()
+	if err := <-waiting; !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadContext: got %v, want context.Canceled", err)
+	}
+}
+
+// slowQRNG delivers random bytes, each Read taking delay
+type slowQRNG time.Duration
+
+func (s slowQRNG) Read(p []byte) error {
+	time.Sleep(time.Duration(s))
+	_, err := rand.Read(p)
+	return err
+}
+
+// TestConditionerReadTimeoutPerCall checks that the timeout bounds the whole
+// call, not each of the many output blocks it conditions
+func TestConditionerReadTimeoutPerCall(t *testing.T) {
+	buf := NewQRNGBuffer(slowQRNG(20*time.Millisecond), 64)
+	defer buf.Stop()
+	fn, err := NewConditioningFunction(CondCBCMACAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewConditioner(buf, fn, 8)
+
+	// 64 blocks of 16 bytes, each waiting for a 20ms source read
+	start := time.Now()
+	err = c.ReadTimeout(make([]byte, 1024), 100*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want ErrTimeout", err)
+	}
+	if d := time.Since(start); d > 400*time.Millisecond {
+		t.Errorf("ReadTimeout(100ms) took %v", d)
+	}
+}
@@ -0,0 +1,20 @@
+package rng
+
+import "context"
+
+// connKey is the context key of the per-connection generator, unexported so
+// only this package can set or read it
+type connKey struct{}
+
+// ContextWithGenerator returns a copy of ctx carrying g as the generator of
+// the connection it belongs to
+func ContextWithGenerator(ctx context.Context, g Generator) context.Context {
+	return context.WithValue(ctx, connKey{}, g)
+}
+
+// GeneratorFromContext returns the per-connection generator stored in ctx by
+// ContextWithGenerator, if any
+func GeneratorFromContext(ctx context.Context) (Generator, bool) {
+	g, ok := ctx.Value(connKey{}).(Generator)
+	return g, ok && g != nil
+}
@@ -0,0 +1,24 @@
+package rng
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGeneratorFromContext(t *testing.T) {
+	if _, ok := GeneratorFromContext(context.Background()); ok {
+		t.Error("generator found in an empty context")
+	}
+	if _, ok := GeneratorFromContext(ContextWithGenerator(context.Background(), nil)); ok {
+		t.Error("nil generator reported as present")
+	}
+
+	d := newTestDRBG(t, AlgoChaCha20)
+	defer d.Zeroize()
+	ctx := ContextWithGenerator(context.Background(), d)
+	ctx = context.WithValue(ctx, struct{}{}, "unrelated")
+	g, ok := GeneratorFromContext(ctx)
+	if !ok || g != Generator(d) {
+		t.Fatalf("got %v, %v; want the stored generator", g, ok)
+	}
+}
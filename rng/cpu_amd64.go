@@ -0,0 +1,19 @@
+package rng
+
+// Implemented in cpu_amd64.s
+func cpuid(leaf, sub uint32) (eax, ebx, ecx, edx uint32)
+func rdrand64() (v uint64, ok bool)
+func rdseed64() (v uint64, ok bool)
+
+// cpuHas reports RDRAND (CPUID.01H:ECX bit 30) and RDSEED
+// (CPUID.(EAX=07H,ECX=0):EBX bit 18) support
+func cpuHas() (rdrand, rdseed bool) {
+	maxLeaf, _, _, _ := cpuid(0, 0)
+	_, _, ecx, _ := cpuid(1, 0)
+	rdrand = ecx&(1<<30) != 0
+	if maxLeaf >= 7 {
+		_, ebx, _, _ := cpuid(7, 0)
+		rdseed = ebx&(1<<18) != 0
+	}
+	return rdrand, rdseed
+}
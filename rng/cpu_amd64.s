@@ -0,0 +1,26 @@
+#include "textflag.h"
+
+// func cpuid(leaf, sub uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL leaf+0(FP), AX
+	MOVL sub+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func rdrand64() (v uint64, ok bool)
+TEXT ·rdrand64(SB), NOSPLIT, $0-9
+	RDRANDQ AX
+	SETCS   ok+8(FP)
+	MOVQ    AX, v+0(FP)
+	RET
+
+// func rdseed64() (v uint64, ok bool)
+TEXT ·rdseed64(SB), NOSPLIT, $0-9
+	RDSEEDQ AX
+	SETCS   ok+8(FP)
+	MOVQ    AX, v+0(FP)
+	RET
@@ -0,0 +1,7 @@
+//go:build !amd64
+
+package rng
+
+func cpuHas() (rdrand, rdseed bool) { return false, false }
+func rdrand64() (v uint64, ok bool) { return 0, false }
+func rdseed64() (v uint64, ok bool) { return 0, false }
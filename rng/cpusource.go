@@ -0,0 +1,120 @@
+package rng
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Built-in sources, accepted wherever a device path is configured
+const (
+	SourceGetrandom = "getrandom" // getrandom(2), optionally :random, :nonblock or :random+nonblock
+	SourceRDSEED    = "rdseed"    // CPU entropy source, full entropy per the vendors
+	SourceRDRAND    = "rdrand"    // CPU DRBG output, reseeded by the same source
+)
+
+// ErrCPUNotSupported is returned when the CPU lacks the instruction
+var ErrCPUNotSupported = errors.New("rng: instruction not supported by this CPU")
+
+// RDRAND may fail transiently, Intel recommends 10 retries. RDSEED fails
+// whenever its conditioner has no fresh output yet and gets more patience.
+const (
+	rdrandRetries = 10
+	rdseedRetries = 1000
+)
+
+// cpuFeatures reports RDRAND and RDSEED support, tests replace it to stand
+// in for a CPU without them
+var cpuFeatures = cpuHas
+
+// CPUSource reads RDSEED or RDRAND, 8 bytes per instruction
+type CPUSource struct {
+	seed bool
+	next func() (uint64, bool)
+}
+
+// NewCPUSource returns an RDSEED (seed true) or RDRAND source, after
+// checking CPUID for the instruction
+func NewCPUSource(seed bool) (*CPUSource, error) {
+	rdrand, rdseed := cpuFeatures()
+	c := &CPUSource{seed: seed, next: rdrand64}
+	if seed {
+		c.next = rdseed64
+	}
+	if (seed && !rdseed) || (!seed && !rdrand) {
+		return nil, fmt.Errorf("%w: %s", ErrCPUNotSupported, c.Name())
+	}
+	return c, nil
+}
+
+// Name returns rdseed or rdrand
+func (c *CPUSource) Name() string {
+	if c.seed {
+		return SourceRDSEED
+	}
+	return SourceRDRAND
+}
+
+// Read fills p, retrying underflows
+func (c *CPUSource) Read(p []byte) error {
+	retries := rdrandRetries
+	if c.seed {
+		retries = rdseedRetries
+	}
+
+	var word [8]byte
+	defer clear(word[:])
+	for len(p) > 0 {
+		v, ok := c.next()
+		for i := 0; !ok && i < retries; i++ {
+			if c.seed {
+				runtime.Gosched() // give the conditioner time to refill
+			}
+			v, ok = c.next()
+		}
+		if !ok {
+			return fmt.Errorf("rng: %s: no output after %d retries", c.Name(), retries)
+		}
+		binary.LittleEndian.PutUint64(word[:], v)
+		p = p[copy(p, word[:]):]
+	}
+	return nil
+}
+
+// BuiltinSource returns the built-in source named by spec. ok is false when
+// spec names none of them, e.g. a device path.
+func BuiltinSource(spec string) (src QRNG, ok bool, err error) {
+	name, opts, _ := strings.Cut(spec, ":")
+	switch name {
+	case SourceRDSEED, SourceRDRAND:
+		if opts != "" {
+			return nil, true, fmt.Errorf("rng: %s takes no options", name)
+		}
+		c, err := NewCPUSource(name == SourceRDSEED)
+		if err != nil {
+			return nil, true, err
+		}
+		return c, true, nil
+	case SourceGetrandom:
+		var random, nonblock bool
+		for _, o := range strings.Split(opts, "+") {
+			switch o {
+			case "":
+			case "random":
+				random = true
+			case "nonblock":
+				nonblock = true
+			default:
+				return nil, true, fmt.Errorf("rng: unknown getrandom option %q", o)
+			}
+		}
+		g, err := NewGetrandom(random, nonblock)
+		if err != nil {
+			return nil, true, err
+		}
+		return g, true, nil
+	}
+	return nil, false, nil
+}
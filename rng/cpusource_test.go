@@ -0,0 +1,95 @@
+package rng
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCPUSource(t *testing.T) {
+	for _, seed := range []bool{true, false} {
+		c, err := NewCPUSource(seed)
+		if errors.Is(err, ErrCPUNotSupported) {
+			t.Logf("%v, skipped", err)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(c.Name(), func(t *testing.T) {
+			// not a multiple of the 8 byte word
+			a, b := make([]byte, 1001), make([]byte, 1001)
+			if err := c.Read(a); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Read(b); err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(a, b) || bytes.Equal(a[992:], make([]byte, 9)) {
+				t.Fatal("repeated or missing output")
+			}
+			if st := StartupHealthTest(c.Name(), c, DefaultMinEntropy); !st.Passed {
+				t.Fatalf("startup health test: %s", st.Error)
+			}
+		})
+	}
+}
+
+// TestCPUSourceNotSupported checks that a CPU without the instructions is
+// reported as such, by NewCPUSource and for the built-in source names
+func TestCPUSourceNotSupported(t *testing.T) {
+	defer func(f func() (bool, bool)) { cpuFeatures = f }(cpuFeatures)
+	cpuFeatures = func() (rdrand, rdseed bool) { return true, false }
+
+	if _, err := NewCPUSource(true); !errors.Is(err, ErrCPUNotSupported) {
+		t.Fatalf("rdseed: got %v, want ErrCPUNotSupported", err)
+	}
+	if _, err := NewCPUSource(false); err != nil {
+		t.Fatalf("rdrand: %v", err)
+	}
+
+	cpuFeatures = func() (rdrand, rdseed bool) { return false, false }
+	for _, spec := range []string{SourceRDSEED, SourceRDRAND} {
+		src, ok, err := BuiltinSource(spec)
+		if !ok || src != nil || !errors.Is(err, ErrCPUNotSupported) {
+			t.Errorf("%s: got %v, %v, %v, want ErrCPUNotSupported", spec, src, ok, err)
+		}
+	}
+}
+
+// underflowing returns a next function that fails fails times before every
+// word it delivers
+func underflowing(fails int) func() (uint64, bool) {
+	n := 0
+	return func() (uint64, bool) {
+		if n++; n <= fails {
+			return 0, false
+		}
+		n = 0
+		return 0x0807060504030201, true
+	}
+}
+
+func TestCPUSourceRetries(t *testing.T) {
+	for _, tc := range []struct {
+		seed    bool
+		retries int
+	}{
+		{false, rdrandRetries},
+		{true, rdseedRetries},
+	} {
+		c := &CPUSource{seed: tc.seed, next: underflowing(tc.retries)}
+		p := make([]byte, 12)
+		if err := c.Read(p); err != nil {
+			t.Fatalf("%s: %d underflows per word: %v", c.Name(), tc.retries, err)
+		}
+		if want := []byte{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4}; !bytes.Equal(p, want) {
+			t.Fatalf("%s: read %v, want %v", c.Name(), p, want)
+		}
+
+		c.next = underflowing(tc.retries + 1)
+		if err := c.Read(p); err == nil {
+			t.Fatalf("%s: read despite %d underflows", c.Name(), tc.retries+1)
+		}
+	}
+}
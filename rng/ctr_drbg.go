@@ -0,0 +1,271 @@
+package rng
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// SP 800-90A Table 3 parameters for CTR_DRBG with AES-256
+const (
+	ctrKeyLen         = 32
+	ctrBlockLen       = aes.BlockSize
+	ctrSeedLen        = ctrKeyLen + ctrBlockLen
+	ctrNonceLen       = ctrBlockLen
+	ctrReseedInterval = 1 << 48
+	ctrMaxRequest     = (1 << 19) / 8 // 64 KiB per Generate call
+	ctrMaxInput       = 1 << 32       // df input limit, in bytes
+)
+
+var (
+	ErrReseedRequired  = errors.New("rng: reseed required")
+	ErrNotInstantiated = errors.New("rng: DRBG not instantiated")
+	ErrRequestTooLarge = errors.New("rng: request exceeds max_number_of_bits_per_request")
+	ErrEntropyLength   = errors.New("rng: invalid entropy input length")
+	ErrInputTooLong    = errors.New("rng: additional input or personalization string too long")
+)
+
+// CTRDRBG is an SP 800-90A Rev. 1 CTR_DRBG instantiated with AES-256.
+// It is not safe for concurrent use; DRBG wraps it with its own mutex.
+type CTRDRBG struct {
+	key           [ctrKeyLen]byte
+	v             [ctrBlockLen]byte
+	block         cipher.Block
+	useDF         bool
+	reseedCounter uint64
+	instantiated  bool
+}
+
+// NewCTRDRBG returns an uninstantiated CTR_DRBG. With useDF the Block_Cipher_df
+// derivation function is applied to all inputs (10.3.2); without it the
+// entropy input must be exactly seedlen (384 bits) long.
+func NewCTRDRBG(useDF bool) *CTRDRBG {
+	return &CTRDRBG{useDF: useDF}
+}
+
+// Instantiate implements CTR_DRBG_Instantiate_algorithm (10.2.1.3).
+// nonce is ignored when no derivation function is used.
+func (c *CTRDRBG) Instantiate(entropy, nonce, personalization []byte) error {
+	var seed [ctrSeedLen]byte
+
+	if c.useDF {
+		if len(entropy) < ctrKeyLen {
+			return ErrEntropyLength
+		}
+		material := make([]byte, 0, len(entropy)+len(nonce)+len(personalization))
+		material = append(material, entropy...)
+		material = append(material, nonce...)
+		material = append(material, personalization...)
+		if err := ctrDF(seed[:], material); err != nil {
+			return err
+		}
+	} else {
+		if len(entropy) != ctrSeedLen {
+			return ErrEntropyLength
+		}
+		if len(personalization) > ctrSeedLen {
+			return ErrInputTooLong
+		}
+		copy(seed[:], personalization)
+		subtle.XORBytes(seed[:], seed[:], entropy)
+	}
+
+	clear(c.key[:])
+	clear(c.v[:])
+	c.setKey()
+	c.update(seed[:])
+	c.reseedCounter = 1
+	c.instantiated = true
+	return nil
+}
+
+// Reseed implements CTR_DRBG_Reseed_algorithm (10.2.1.4).
+func (c *CTRDRBG) Reseed(entropy, additional []byte) error {
+	if !c.instantiated {
+		return ErrNotInstantiated
+	}
+
+	seed, err := c.seedMaterial(entropy, additional)
+	if err != nil {
+		return err
+	}
+	c.update(seed[:])
+	c.reseedCounter = 1
+	return nil
+}
+
+// Generate implements CTR_DRBG_Generate_algorithm (10.2.1.5) and fills out,
+// which may be at most 64 KiB long. It returns ErrReseedRequired once the
+// reseed interval is exhausted.
+func (c *CTRDRBG) Generate(out, additional []byte) error {
+	if !c.instantiated {
+		return ErrNotInstantiated
+	}
+	if len(out) > ctrMaxRequest {
+		return ErrRequestTooLarge
+	}
+	if c.reseedCounter > ctrReseedInterval {
+		return ErrReseedRequired
+	}
+
+	var adin [ctrSeedLen]byte
+	if len(additional) > 0 {
+		if c.useDF {
+			if err := ctrDF(adin[:], additional); err != nil {
+				return err
+			}
+		} else {
+			if len(additional) > ctrSeedLen {
+				return ErrInputTooLong
+			}
+			copy(adin[:], additional)
+		}
+		c.update(adin[:])
+	}
+
+	var block [ctrBlockLen]byte
+	for off := 0; off < len(out); off += ctrBlockLen {
+		ctrIncrement(&c.v)
+		c.block.Encrypt(block[:], c.v[:])
+		copy(out[off:], block[:])
+	}
+	clear(block[:])
+
+	c.update(adin[:])
+	c.reseedCounter++
+	return nil
+}
+
+// ReseedCounter returns the number of Generate calls since the last
+// instantiate or reseed, plus one (as defined in SP 800-90A).
+func (c *CTRDRBG) ReseedCounter() uint64 {
+	return c.reseedCounter
+}
+
+// Zeroize wipes the working state and leaves the DRBG uninstantiated. The
+// expanded key schedule belongs to crypto/aes and is only dropped here.
+func (c *CTRDRBG) Zeroize() {
+	clear(c.key[:])
+	clear(c.v[:])
+	c.block = nil
+	c.reseedCounter = 0
+	c.instantiated = false
+}
+
+func (c *CTRDRBG) seedMaterial(entropy, additional []byte) ([ctrSeedLen]byte, error) {
+	var seed [ctrSeedLen]byte
+
+	if c.useDF {
+		if len(entropy) < ctrKeyLen {
+			return seed, ErrEntropyLength
+		}
+		material := make([]byte, 0, len(entropy)+len(additional))
+		material = append(material, entropy...)
+		material = append(material, additional...)
+		return seed, ctrDF(seed[:], material)
+	}
+
+	if len(entropy) != ctrSeedLen {
+		return seed, ErrEntropyLength
+	}
+	if len(additional) > ctrSeedLen {
+		return seed, ErrInputTooLong
+	}
+	copy(seed[:], additional)
+	subtle.XORBytes(seed[:], seed[:], entropy)
+	return seed, nil
+}
+
+// update implements CTR_DRBG_Update (10.2.1.2)
+func (c *CTRDRBG) update(provided []byte) {
+	var temp [ctrSeedLen]byte
+	for off := 0; off < ctrSeedLen; off += ctrBlockLen {
+		ctrIncrement(&c.v)
+		c.block.Encrypt(temp[off:off+ctrBlockLen], c.v[:])
+	}
+	subtle.XORBytes(temp[:], temp[:], provided)
+
+	copy(c.key[:], temp[:ctrKeyLen])
+	copy(c.v[:], temp[ctrKeyLen:])
+	clear(temp[:])
+	c.setKey()
+}
+
+func (c *CTRDRBG) setKey() {
+	b, err := aes.NewCipher(c.key[:])
+	if err != nil {
+		panic(err) // key length is fixed at 32 bytes
+	}
+	c.block = b
+}
+
+// ctrIncrement adds one to v, modulo 2^128
+func ctrIncrement(v *[ctrBlockLen]byte) {
+	for i := len(v) - 1; i >= 0; i-- {
+		v[i]++
+		if v[i] != 0 {
+			return
+		}
+	}
+}
+
+// ctrDF implements Block_Cipher_df (10.3.2) and fills out with
+// len(out)*8 bits derived from input.
+func ctrDF(out, input []byte) error {
+	if len(input) >= ctrMaxInput {
+		return ErrInputTooLong
+	}
+
+	// S = L || N || input || 0x80, zero padded to a multiple of the block size
+	s := make([]byte, 8, 8+len(input)+ctrBlockLen)
+	binary.BigEndian.PutUint32(s[0:4], uint32(len(input)))
+	binary.BigEndian.PutUint32(s[4:8], uint32(len(out)))
+	s = append(s, input...)
+	s = append(s, 0x80)
+	for len(s)%ctrBlockLen != 0 {
+		s = append(s, 0)
+	}
+
+	var k [ctrKeyLen]byte
+	for i := range k {
+		k[i] = byte(i)
+	}
+	b, err := aes.NewCipher(k[:])
+	if err != nil {
+		return err
+	}
+
+	var temp [ctrSeedLen]byte
+	var iv [ctrBlockLen]byte
+	for i := 0; i*ctrBlockLen < ctrSeedLen; i++ {
+		binary.BigEndian.PutUint32(iv[0:4], uint32(i))
+		ctrBCC(b, temp[i*ctrBlockLen:(i+1)*ctrBlockLen], iv[:], s)
+	}
+
+	b, err = aes.NewCipher(temp[:ctrKeyLen])
+	if err != nil {
+		return err
+	}
+	x := temp[ctrKeyLen:]
+	for off := 0; off < len(out); off += ctrBlockLen {
+		b.Encrypt(x, x)
+		copy(out[off:], x)
+	}
+
+	clear(temp[:])
+	clear(s)
+	return nil
+}
+
+// ctrBCC implements BCC (10.3.3) over iv || data, writing one block to out
+func ctrBCC(b cipher.Block, out, iv, data []byte) {
+	var chain [ctrBlockLen]byte
+	b.Encrypt(chain[:], iv)
+	for off := 0; off < len(data); off += ctrBlockLen {
+		subtle.XORBytes(chain[:], chain[:], data[off:off+ctrBlockLen])
+		b.Encrypt(chain[:], chain[:])
+	}
+	copy(out, chain[:])
+}
@@ -1,26 +1,32 @@
 package rng
 
 import (
-	"golang.org/x/crypto/chacha20"
-	"crypto/cipher"
-	"crypto/sha512"
 	//"crypto/sha256"
+	"io"
+	"net/http"
 	"strconv"
 	"sync"
 	"time"
-	"net/http"
-	//"io"
 )
 
-// DRBG represents a deterministic random byte generator with observability metadata
+// DRBG represents a deterministic random byte generator with observability metadata.
+// It wraps one registered Mechanism and implements Generator.
 type DRBG struct {
 	mu sync.Mutex
-	stream cipher.Stream
 	// Crypto state
-	key      [32]byte
-	nonce    [12]byte
-	cipher   *chacha20.Cipher
-	reseeded time.Time
+	mech       Mechanism
+	nonceLen   int
+	maxRequest int
+	reseeded   time.Time
+
+	// Reseed enforcement: mechanism requests and bytes since the last reseed
+	generates     uint64
+	bytes         uint64
+	maxGenerates  uint64
+	maxBytes      uint64
+	forcedReseeds uint64
+	refused       uint64
+	parent        Generator // reseed source for derived children
 
 	// Observability / header metadata
 	version        string
@@ -32,31 +38,49 @@ type DRBG struct {
 
 	// optional: pointer to external entropy buffer
 	entropyBuf *QRNGBuffer
+	// optional: conditions forced reseeds instead of raw buffer bytes
+	conditioner *Conditioner
 }
 
 // Metadata contains all info needed for headers / JSON
 type Metadata struct {
-	Version			string
-	Source			string
-	DRBG			string
-	ReseedAgeMs		time.Duration
-	ReseedIntervalMs	int64
-	ReseedSizeBits		int
-	EntropyBufferedBytes	int
-	EntropyFillPct		int
+	Version              string
+	Source               string
+	DRBG                 string
+	ReseedAge            time.Duration
+	ReseedIntervalMs     int64
+	ReseedSizeBits       int
+	EntropyBufferedBytes int
+	EntropyFillPct       int
+
+	GenerateCount     uint64 // mechanism requests since last reseed
+	BytesSinceReseed  uint64
+	MaxGenerates      uint64
+	MaxBytesPerReseed uint64
+	ForcedReseeds     uint64 // reseeds triggered by the limits
+	RefusedRequests   uint64 // requests refused because no reseed was possible
+	Shards            int    // independent instances behind the generator
 }
 
+// Default per-key limits. SP 800-90A allows up to 2^48 requests between
+// reseeds; the service reseeds far earlier than that.
+const (
+	DefaultMaxGenerates      = 1 << 24
+	DefaultMaxBytesPerReseed = 1 << 32 // 4 GiB
+	forcedReseedBytes        = 64      // 512 bits, same as the reseed loop
+)
+
 // HealthInfo contains all info needed to generate JSON
 type HealthInfo struct {
-	Status			string `json:"status"`
-	Version			string `json:"rng_version"`
-	Source			string `json:"rng_source"`
-	DRBG			string `json:"drbg"`
-	ReseedAgeMs		int64  `json:"reseed_age_ms"`
-	ReseedIntervalMs	int64  `json:"reseed_interval_ms"`
-	ReseedSizeBits		int    `json:"reseed_size_bits"`
-	EntropyBufferedBytes	int    `json:"entropy_buffered_kb"`
-	EntropyFillPct		int    `json:"entropy_buffered_pct"`
+	Status               string `json:"status"`
+	Version              string `json:"rng_version"`
+	Source               string `json:"rng_source"`
+	DRBG                 string `json:"drbg"`
+	ReseedAgeMs          int64  `json:"reseed_age_ms"`
+	ReseedIntervalMs     int64  `json:"reseed_interval_ms"`
+	ReseedSizeBits       int    `json:"reseed_size_bits"`
+	EntropyBufferedBytes int    `json:"entropy_buffered_kb"`
+	EntropyFillPct       int    `json:"entropy_buffered_pct"`
 }
 
 func (d *DRBG) SetEntropyBuffer(q *QRNGBuffer) {
@@ -65,70 +89,223 @@ func (d *DRBG) SetEntropyBuffer(q *QRNGBuffer) {
 	d.entropyBuf = q
 }
 
-// NewDRBG creates a new DRBG instance from a seed
-//func NewDRBG(seed []byte, noncee []byte) (*DRBG, error) {
+// SetConditioner makes forced reseeds draw conditioned full-entropy input
+func (d *DRBG) SetConditioner(c *Conditioner) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.conditioner = c
+}
+
+// NewDRBGWithAlgo creates a new DRBG instance running the named algorithm.
+// The trailing bytes of seed are used as nonce where the mechanism takes one.
+func NewDRBGWithAlgo(algo string, seed []byte) (*DRBG, error) {
+	d, err := New(algo)
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) < seedEntropyLen+d.nonceLen {
+		return nil, ErrEntropyLength
+	}
+	split := len(seed) - d.nonceLen
+
+	if err := d.Instantiate(seed[:split], seed[split:], nil); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// NewDRBG creates a new ChaCha20 DRBG instance from a seed
+// func NewDRBG(seed []byte, noncee []byte) (*DRBG, error) {
 func NewDRBG(seed []byte) (*DRBG, error) {
-	if len(seed) < 32 { panic("seed too short") }
-	//if len(noncee) < 12 { panic("failed to generate nonce") }
-	h := sha512.Sum512(seed)
-	//n := sha256.Sum256(noncee)
-
-	//if _, err := crypto/rand.Read(nonce); err != nil
-
-	var key [32]byte
-	var nonce [12]byte
-	//nonce := make([]byte, 12)
-	// idea:  derive deterministically from conn id
-	copy(key[:], h[:32])
-	copy(nonce[:], h[32:44])
-	//copy(nonce[:], n[:12])
-
-	c, err := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
-	if err != nil { return nil, err }
-
-	return &DRBG{
-		key:      key,
-		nonce:    nonce,
-		cipher:   c,
-		reseeded: time.Now(),
-	}, nil
+	return NewDRBGWithAlgo(AlgoChaCha20, seed)
 }
 
-// DRBG per-connection seed
-func NewConnectionDRBG(d *DRBG) (*DRBG, error) {
-	seed, err := d.Derive(32) // 256-bit seed
-	if err != nil { return nil, err }
+// DRBG per-connection seed, the child runs the same algorithm and limits as
+// parent, and reseeds from it when a limit is reached
+func NewConnectionDRBG(parent Generator) (Generator, error) {
+	meta := parent.Metadata()
+	child, err := New(meta.DRBG)
+	if err != nil {
+		return nil, err
+	}
+	child.parent = parent
+	child.SetLimits(meta.MaxGenerates, meta.MaxBytesPerReseed)
+
+	seed := make([]byte, seedEntropyLen+child.nonceLen) // 256-bit seed, plus nonce
+	defer clear(seed)
+	if err := parent.Generate(seed, nil); err != nil {
+		return nil, err
+	}
 
 	//nonce := make([]byte, 12) // 96-bit nonce
 	//copy(nonce, seed[:12])
 
 	//return NewDRBG(seed, nonce)
-	return NewDRBG(seed)
+	if err := child.Instantiate(seed[:seedEntropyLen], seed[seedEntropyLen:], nil); err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// Instantiate seeds the underlying mechanism
+func (d *DRBG) Instantiate(entropy, nonce, personalization []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.mech.Instantiate(entropy, nonce, personalization); err != nil {
+		return err
+	}
+	d.resetCounters()
+	return nil
 }
 
 // Reseed mixes new entropy into the DRBG
-func (d *DRBG) Reseed(seed []byte) error {
+func (d *DRBG) Reseed(entropy, additional []byte) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	h := sha512.Sum512(append(d.key[:], seed...))
-	copy(d.key[:], h[:32])
-	copy(d.nonce[:], h[32:44])
+	if err := d.mech.Reseed(entropy, additional); err != nil {
+		return err
+	}
+	d.resetCounters()
+	return nil
+}
 
-	c, err := chacha20.NewUnauthenticatedCipher(d.key[:], d.nonce[:])
-	if err != nil {
+// Generate fills out with pseudo-random bytes, additional input is mixed
+// into the first mechanism request. When the request or byte limit is
+// reached the DRBG reseeds itself first, or fails with ErrReseedRequired.
+func (d *DRBG) Generate(out, additional []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// SP 800-90A mechanisms cap each Generate call at 64 KiB, and no call
+	// may run past the byte limit
+	for len(out) > 0 {
+		if d.limitReached() {
+			if err := d.forceReseed(); err != nil {
+				return err
+			}
+		}
+		n := min(len(out), d.maxRequest)
+		if left := d.maxBytes - d.bytes; left < uint64(n) {
+			n = int(left)
+		}
+
+		err := d.mech.Generate(out[:n], additional)
+		if err == ErrReseedRequired {
+			if err = d.forceReseed(); err == nil {
+				err = d.mech.Generate(out[:n], additional)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		d.generates++
+		d.bytes += uint64(n)
+		out = out[n:]
+		additional = nil
+	}
+	return nil
+}
+
+// SetLimits sets the number of mechanism requests and output bytes allowed
+// between reseeds, zero keeps the default
+func (d *DRBG) SetLimits(maxGenerates, maxBytes uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if maxGenerates == 0 {
+		maxGenerates = DefaultMaxGenerates
+	}
+	if maxBytes == 0 {
+		maxBytes = DefaultMaxBytesPerReseed
+	}
+	d.maxGenerates = maxGenerates
+	d.maxBytes = maxBytes
+}
+
+// limitReached reports whether the request or byte budget is used up
+func (d *DRBG) limitReached() bool {
+	return d.generates >= d.maxGenerates || d.bytes >= d.maxBytes
+}
+
+// forceReseed reseeds from the conditioner or entropy buffer without
+// blocking, or from the parent for derived children. Called with d.mu held.
+func (d *DRBG) forceReseed() error {
+	var seed []byte
+	switch {
+	case d.conditioner != nil:
+		b := make([]byte, forcedReseedBytes)
+		if d.conditioner.TryRead(b) {
+			seed = b
+		} else {
+			clear(b) // may hold part of the output
+		}
+	case d.entropyBuf != nil:
+		b := make([]byte, forcedReseedBytes)
+		if d.entropyBuf.TryRead(b) {
+			seed = b
+		}
+	case d.parent != nil:
+		b := make([]byte, forcedReseedBytes)
+		if err := d.parent.Generate(b, nil); err == nil {
+			seed = b
+		}
+	}
+	if seed == nil {
+		d.refused++
+		return ErrReseedRequired
+	}
+	defer clear(seed)
+
+	if err := d.mech.Reseed(seed, nil); err != nil {
+		d.refused++
 		return err
 	}
-	d.cipher = c
-	d.reseeded = time.Now()
+	d.forcedReseeds++
+	d.resetCounters()
 	return nil
 }
 
-// Read fills p with pseudo-random bytes
-func (d *DRBG) Read(p []byte) {
+func (d *DRBG) resetCounters() {
+	d.generates = 0
+	d.bytes = 0
+	d.reseeded = time.Now()
+}
+
+// Zeroize wipes the mechanism state and drops the link to the parent, the
+// DRBG must be instantiated again before use. Safe to call more than once.
+func (d *DRBG) Zeroize() {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	d.cipher.XORKeyStream(p, p)
+	d.mech.Zeroize()
+	d.parent = nil
+	d.generates = 0
+	d.bytes = 0
+}
+
+// Read fills p with pseudo-random bytes, so a DRBG can stand in for
+// crypto/rand.Reader (rsa.GenerateKey, io.ReadFull, ...). It only fails when
+// a forced reseed is impossible, in which case p is wiped: output generated
+// before the failure is not handed out.
+func (d *DRBG) Read(p []byte) (int, error) {
+	if err := d.Generate(p, nil); err != nil {
+		clear(p)
+		return 0, err
+	}
+	return len(p), nil
+}
+
+var (
+	_ io.Reader   = (*DRBG)(nil)
+	_ io.WriterTo = (*DRBG)(nil)
+)
+
+// WriteTo streams output to w until w or the DRBG fails, so io.Copy(w, d)
+// only ends with an error; use CopyN for a fixed amount
+func (d *DRBG) WriteTo(w io.Writer) (int64, error) {
+	return CopyN(w, d, -1)
 }
 
 // ReseedAge returns how long since last reseed
@@ -140,134 +317,153 @@ func (d *DRBG) ReseedAge() time.Duration {
 
 // WriteHeaders writes all observability headers to an http.ResponseWriter
 func (d *DRBG) WriteHeaders(w http.ResponseWriter) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-
-	now := time.Now()
+	d.Metadata().WriteHeaders(w)
+}
 
-	w.Header().Set("X-RNG-Version", d.version)
-	w.Header().Set("X-RNG-Source", d.source)
-	w.Header().Set("X-RNG-DRBG", d.algo)
+// WriteHeaders writes the metadata as observability headers
+func (m Metadata) WriteHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-RNG-Version", m.Version)
+	w.Header().Set("X-RNG-Source", m.Source)
+	w.Header().Set("X-RNG-DRBG", m.DRBG)
 
 	w.Header().Set(
 		"X-RNG-Reseed-Age-ms",
-		strconv.FormatInt(now.Sub(d.reseeded).Milliseconds(), 10),
+		strconv.FormatInt(m.ReseedAge.Milliseconds(), 10),
 	)
 
 	w.Header().Set(
 		"X-RNG-Reseed-Interval-ms",
-		strconv.FormatInt(d.reseedInterval.Milliseconds(), 10),
+		strconv.FormatInt(m.ReseedIntervalMs, 10),
 	)
 
 	w.Header().Set(
 		"X-RNG-Reseed-Size-bits",
-		strconv.Itoa(d.reseedSizeBits),
+		strconv.Itoa(m.ReseedSizeBits),
 	)
 
-	bufKB := 0
-	bufPct := 0
+	w.Header().Set("X-RNG-Entropy-Buffered-kB", strconv.Itoa(m.EntropyBufferedBytes/1024))
+	w.Header().Set("X-RNG-Entropy-Buffered-%", strconv.Itoa(m.EntropyFillPct))
 
-	if d.entropyBuf != nil {
-		d.entropyBuf.mu.Lock()
-		bufKB = len(d.entropyBuf.buf) / 1024
-		bufPct = (len(d.entropyBuf.buf) * 100) / d.entropyBuf.capacity
-		d.entropyBuf.mu.Unlock()
-	}
-	w.Header().Set("X-RNG-Entropy-Buffered-kB", strconv.Itoa(bufKB))
-	w.Header().Set("X-RNG-Entropy-Buffered-%", strconv.Itoa(bufPct))
+	w.Header().Set("X-RNG-Generate-Count", strconv.FormatUint(m.GenerateCount, 10))
+	w.Header().Set("X-RNG-Max-Generates", strconv.FormatUint(m.MaxGenerates, 10))
+	w.Header().Set("X-RNG-Bytes-Since-Reseed", strconv.FormatUint(m.BytesSinceReseed, 10))
+	w.Header().Set("X-RNG-Max-Bytes-Per-Reseed", strconv.FormatUint(m.MaxBytesPerReseed, 10))
 }
 
-// SetMetadata sets all DRBG metadata
-func (d *DRBG) SetMetadata(version, source, algo string, interval time.Duration, sizeBits int, buf *QRNGBuffer) {
+// SetMetadata sets all DRBG metadata, the algorithm name is set by the constructor
+func (d *DRBG) SetMetadata(version, source string, interval time.Duration, sizeBits int, buf *QRNGBuffer) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	d.version = version
 	d.source = source
-	d.algo = algo
 	d.reseedInterval = interval
 	d.reseedSizeBits = sizeBits
 	d.entropyBuf = buf
 }
 
-// GetMetadata returns a snapshot of metadata
-//func (d *DRBG) GetMetadata() (version, source, algo string, reseedInterval, reseedSizeBits, bufKB, bufPct) 
-func (d *DRBG) GetMetadata() Metadata {
-    bufKB := 0
-    bufPct := 0
-
-    if d.entropyBuf != nil {
-        //d.mu.Lock()
-        d.entropyBuf.mu.Lock()
-        //defer d.mu.Unlock()
-        bufKB = len(d.entropyBuf.buf)
-        bufPct = len(d.entropyBuf.buf) * 100 / d.entropyBuf.capacity
-        d.entropyBuf.mu.Unlock()
-    }
-
-    return Metadata{
-    	Version:		d.version,
-    	Source:			d.source,
-    	DRBG:			d.algo,
-	//ReseedAgeMs:		now.Sub(d.reseeded).Milliseconds(),
-    	ReseedIntervalMs:	d.reseedInterval.Milliseconds(),
-    	ReseedSizeBits:		d.reseedSizeBits,
-    	EntropyBufferedBytes:	bufKB,
-    	EntropyFillPct:		bufPct,
-    }
-    
+// Metadata returns a snapshot of metadata
+// func (d *DRBG) GetMetadata() (version, source, algo string, reseedInterval, reseedSizeBits, bufKB, bufPct)
+func (d *DRBG) Metadata() Metadata {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	bufBytes := 0
+	bufPct := 0
+	source := d.source
+
+	if d.entropyBuf != nil {
+		bufBytes = d.entropyBuf.Len()
+		bufPct = bufBytes * 100 / d.entropyBuf.capacity
+
+		// report the source actually feeding the buffer, if it can tell
+		if name := d.entropyBuf.SourceName(); name != "" {
+			source = name
+		}
+	}
+
+	return Metadata{
+		Version:              d.version,
+		Source:               source,
+		DRBG:                 d.algo,
+		ReseedAge:            time.Since(d.reseeded),
+		ReseedIntervalMs:     d.reseedInterval.Milliseconds(),
+		ReseedSizeBits:       d.reseedSizeBits,
+		EntropyBufferedBytes: bufBytes,
+		EntropyFillPct:       bufPct,
+		GenerateCount:        d.generates,
+		BytesSinceReseed:     d.bytes,
+		MaxGenerates:         d.maxGenerates,
+		MaxBytesPerReseed:    d.maxBytes,
+		ForcedReseeds:        d.forcedReseeds,
+		RefusedRequests:      d.refused,
+		Shards:               1,
+	}
 }
 
-func (d *DRBG) ReadInto(dst []byte) {
-    d.mu.Lock()
-    defer d.mu.Unlock()
-    d.stream.XORKeyStream(dst, dst)
-    // update counters, bytes generated, reseed checks, etc
+// bufPool holds the output buffers of CopyN, they are wiped before reuse
+var bufPool = sync.Pool{
+	New: func() any {
+		return &copyBuf{b: make([]byte, 1<<20)} // 1 MB
+	},
 }
 
-func (d *DRBG) Write(p []byte) (int, error) {
-    d.ReadInto(p)
-    return len(p), nil
+// copyBuf is a pooled output buffer that tracks how much of it was handed out
+type copyBuf struct {
+	b    []byte
+	used int // largest chunk, only that much needs wiping
 }
 
-// for later fix, 
-/*
-func (d *DRBG) Read(p []byte) (int, error) {
-    // fill p
-    return len(p), nil
+// next returns a chunk of at most remaining bytes, the whole buffer if
+// remaining is negative
+func (c *copyBuf) next(remaining int64) []byte {
+	chunk := c.b
+	if remaining >= 0 && remaining < int64(len(chunk)) {
+		chunk = chunk[:remaining]
+	}
+	c.used = max(c.used, len(chunk))
+	return chunk
 }
-func (d *DRBG) Derive(seedSize int) ([]byte, error) {
-	seed := make([]byte, seedSize)
-	_, err := d.Read(seed)
-	if err != nil {
-		return nil, err
+
+// wipe clears every byte handed out so far
+func (c *copyBuf) wipe() {
+	clear(c.b[:c.used])
+	c.used = 0
+}
+
+// CopyN writes n bytes from g to w through pooled buffers, or streams until
+// an error when n is negative. It returns the number of bytes written.
+func CopyN(w io.Writer, g Generator, n int64) (int64, error) {
+	buf := bufPool.Get().(*copyBuf)
+	defer func() {
+		buf.wipe()
+		bufPool.Put(buf)
+	}()
+
+	var written int64
+	for n < 0 || written < n {
+		remaining := int64(-1)
+		if n >= 0 {
+			remaining = n - written
+		}
+		chunk := buf.next(remaining)
+		if err := g.Generate(chunk, nil); err != nil {
+			return written, err
+		}
+		m, err := w.Write(chunk)
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
 	}
-	return seed, nil
+	return written, nil
 }
-*/
 
+// Derive returns seedSize bytes of output, e.g. to seed another generator
 func (d *DRBG) Derive(seedSize int) ([]byte, error) {
 	seed := make([]byte, seedSize)
-	d.Read(seed)
+	if _, err := d.Read(seed); err != nil {
+		return nil, err
+	}
 	return seed, nil
 }
-
-/*
-func (d *DRBG) WriteTo(w io.Writer, n int) error {
-    //buf := d.bufPool.Get().([]byte)
-    buf := d.entropyBuf.Get()
-    defer d.bufPool.Put(buf)
-
-    for n > 0 {
-        chunk := min(n, len(buf))
-        d.Fill(buf[:chunk])
-        _, err := w.Write(buf[:chunk])
-        if err != nil {
-            return err
-        }
-        n -= chunk
-    }
-    return nil
-}
-*/
-
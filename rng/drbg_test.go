@@ -0,0 +1,169 @@
+package rng
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// newTestDRBG returns a DRBG of algo seeded with a fixed seed
+func newTestDRBG(t testing.TB, algo string) *DRBG {
+	t.Helper()
+	d, err := NewDRBGWithAlgo(algo, make([]byte, seedEntropyLen+mechanismNonceLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDRBGByteLimitForcesReseed(t *testing.T) {
+	for _, algo := range []string{AlgoChaCha20, AlgoCTRAES256, AlgoHMACSHA512, AlgoHashSHA512} {
+		t.Run(algo, func(t *testing.T) {
+			parent := newTestDRBG(t, algo)
+			defer parent.Zeroize()
+			g, err := NewConnectionDRBG(parent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			child := g.(*DRBG)
+			defer child.Zeroize()
+			child.SetLimits(0, 1024)
+
+			if err := child.Generate(make([]byte, 1<<20), nil); err != nil {
+				t.Fatal(err)
+			}
+			m := child.Metadata()
+			// the first KiB needs no reseed, each following one does
+			if m.ForcedReseeds != 1<<10-1 {
+				t.Errorf("%d forced reseeds, want %d", m.ForcedReseeds, 1<<10-1)
+			}
+			if m.BytesSinceReseed > 1024 {
+				t.Errorf("%d bytes since reseed, limit 1024", m.BytesSinceReseed)
+			}
+		})
+	}
+}
+
+func TestDRBGByteLimitWithoutSource(t *testing.T) {
+	d := newTestDRBG(t, AlgoChaCha20)
+	defer d.Zeroize()
+	d.SetLimits(0, 1024)
+
+	if err := d.Generate(make([]byte, 1024), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Generate(make([]byte, 1), nil); !errors.Is(err, ErrReseedRequired) {
+		t.Fatalf("got %v past the byte limit, want ErrReseedRequired", err)
+	}
+	if m := d.Metadata(); m.RefusedRequests != 1 || m.BytesSinceReseed != 1024 {
+		t.Errorf("refused %d, %d bytes since reseed", m.RefusedRequests, m.BytesSinceReseed)
+	}
+}
+
+func TestDRBGGenerateLimit(t *testing.T) {
+	d := newTestDRBG(t, AlgoHMACSHA512)
+	defer d.Zeroize()
+	d.SetLimits(2, 0)
+
+	// two mechanism requests of at most maxRequest bytes are allowed
+	if err := d.Generate(make([]byte, 2*d.maxRequest), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Generate(make([]byte, 1), nil); !errors.Is(err, ErrReseedRequired) {
+		t.Fatalf("got %v past the request limit, want ErrReseedRequired", err)
+	}
+}
+
+// failingWriter accepts max bytes and fails after
+type failingWriter struct{ max int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		n := w.max
+		w.max = 0
+		return n, errors.New("short write")
+	}
+	w.max -= len(p)
+	return len(p), nil
+}
+
+func TestCopyN(t *testing.T) {
+	d := newTestDRBG(t, AlgoChaCha20)
+	defer d.Zeroize()
+
+	var out bytes.Buffer
+	n, err := CopyN(&out, d, 3<<20+5)
+	if err != nil || n != 3<<20+5 || out.Len() != 3<<20+5 {
+		t.Fatalf("copied %d (%d buffered): %v", n, out.Len(), err)
+	}
+
+	n, err = CopyN(&failingWriter{max: 100}, d, -1)
+	if err == nil || n != 100 {
+		t.Fatalf("streamed %d bytes: %v", n, err)
+	}
+}
+
+// TestCopyBufWipe checks that wipe clears every chunk handed out, the
+// largest one included
+func TestCopyBufWipe(t *testing.T) {
+	c := &copyBuf{b: make([]byte, 256)}
+	for _, remaining := range []int64{10, 100, 3} {
+		chunk := c.next(remaining)
+		if len(chunk) != int(remaining) {
+			t.Fatalf("chunk of %d bytes for %d remaining", len(chunk), remaining)
+		}
+		for i := range chunk {
+			chunk[i] = 0xff
+		}
+	}
+	if c.used != 100 {
+		t.Fatalf("%d bytes used, want 100", c.used)
+	}
+	c.wipe()
+	if !bytes.Equal(c.b, make([]byte, len(c.b))) || c.used != 0 {
+		t.Fatal("buffer not wiped")
+	}
+
+	// streaming hands out the whole buffer
+	chunk := c.next(-1)
+	for i := range chunk {
+		chunk[i] = 0xff
+	}
+	c.wipe()
+	if !bytes.Equal(c.b, make([]byte, len(c.b))) {
+		t.Fatal("buffer not wiped after streaming")
+	}
+}
+
+// TestDRBGReadWipesOnError checks that output generated before a failed
+// forced reseed does not reach the caller
+func TestDRBGReadWipesOnError(t *testing.T) {
+	d := newTestDRBG(t, AlgoChaCha20)
+	defer d.Zeroize()
+	d.SetLimits(0, 1024)
+
+	p := make([]byte, 2048)
+	n, err := d.Read(p)
+	if !errors.Is(err, ErrReseedRequired) || n != 0 {
+		t.Fatalf("read %d: %v, want ErrReseedRequired", n, err)
+	}
+	if m := d.Metadata(); m.BytesSinceReseed != 1024 {
+		t.Fatalf("%d bytes generated before the failure, want 1024", m.BytesSinceReseed)
+	}
+	if !bytes.Equal(p, make([]byte, len(p))) {
+		t.Fatal("partial output left in p")
+	}
+}
+
+func BenchmarkCopyNSmall(b *testing.B) {
+	d := newTestDRBG(b, AlgoChaCha20)
+	defer d.Zeroize()
+	b.SetBytes(32)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := CopyN(io.Discard, d, 32); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package rng
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Online SP 800-90B section 6.3 min-entropy estimation. The estimators run
+// on a rolling window of raw samples, far shorter than the 1M samples of a
+// full validation, so they are an early warning and a scaling input, not a
+// certification.
+const (
+	estimateWindow   = 8192 // bytes kept per source
+	estimateInterval = time.Second
+	estimateZ        = 2.576 // 99% confidence bound used throughout 6.3
+)
+
+// Estimate is the min-entropy of one source in bits per byte. The binary
+// estimators run on the bitstring of the samples and are scaled by 8, as in
+// SP 800-90B 3.1.3; MinEntropy is the smallest of them.
+type Estimate struct {
+	MostCommon  float64 `json:"mcv"`         // 6.3.1 on bytes
+	Collision   float64 `json:"collision"`   // 6.3.2 on bits, x8
+	Markov      float64 `json:"markov"`      // 6.3.3 on bits, x8
+	Compression float64 `json:"compression"` // 6.3.4 on bits, x8
+	MinEntropy  float64 `json:"min_entropy"`
+	Samples     int     `json:"samples"`
+}
+
+// EntropyEstimator keeps the most recent raw samples of one source and
+// re-estimates its min-entropy once the window has been refreshed
+type EntropyEstimator struct {
+	mu     sync.Mutex
+	window [estimateWindow]byte
+	bits   [estimateWindow * 8]byte // scratch bitstring of the window
+	pos    int
+	filled int
+	fresh  int // samples since the last estimate
+	last   time.Time
+	est    Estimate
+	valid  bool
+}
+
+// NewEntropyEstimator returns an estimator without samples
+func NewEntropyEstimator() *EntropyEstimator {
+	return &EntropyEstimator{}
+}
+
+// Sample adds raw source bytes. Only the tail of a large block is kept.
+func (e *EntropyEstimator) Sample(block []byte) {
+	if len(block) > estimateWindow {
+		block = block[len(block)-estimateWindow:]
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for len(block) > 0 {
+		n := copy(e.window[e.pos:], block)
+		block = block[n:]
+		e.pos = (e.pos + n) % estimateWindow
+		e.filled = min(e.filled+n, estimateWindow)
+		e.fresh += n
+	}
+	if e.filled == estimateWindow && e.fresh >= estimateWindow && time.Since(e.last) >= estimateInterval {
+		e.est = estimate(e.window[:], e.bits[:])
+		clear(e.bits[:])
+		e.valid = true
+		e.fresh = 0
+		e.last = time.Now()
+	}
+}
+
+// Estimate returns the latest estimate, false until a full window was seen
+func (e *EntropyEstimator) Estimate() (Estimate, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.est, e.valid
+}
+
+// Zeroize wipes the sampled bytes, the last estimate is kept
+func (e *EntropyEstimator) Zeroize() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	clear(e.window[:])
+	clear(e.bits[:])
+	e.pos, e.filled, e.fresh = 0, 0, 0
+}
+
+// EstimateMinEntropy runs the most common value estimate on the bytes and
+// the collision, Markov and compression estimates on their bits
+func EstimateMinEntropy(samples []byte) Estimate {
+	return estimate(samples, make([]byte, len(samples)*8))
+}
+
+// estimate is EstimateMinEntropy with caller supplied room for the bits
+func estimate(samples, bits []byte) Estimate {
+	for i, b := range samples {
+		for j := range 8 {
+			bits[i*8+j] = b >> (7 - j) & 1
+		}
+	}
+
+	est := Estimate{
+		MostCommon:  mostCommonEstimate(samples),
+		Collision:   8 * collisionEstimate(bits),
+		Markov:      8 * markovEstimate(bits),
+		Compression: 8 * compressionEstimate(bits),
+		Samples:     len(samples),
+	}
+	bitMCV := 8 * mostCommonEstimate(bits)
+	est.MinEntropy = min(est.MostCommon, bitMCV, est.Collision, est.Markov, est.Compression)
+	return est
+}
+
+// mostCommonEstimate implements 6.3.1 on byte or bit samples
+func mostCommonEstimate(s []byte) float64 {
+	var counts [256]int
+	maxCount := 0
+	for _, v := range s {
+		counts[v]++
+		maxCount = max(maxCount, counts[v])
+	}
+	l := float64(len(s))
+	p := float64(maxCount) / l
+	pu := min(1, p+estimateZ*math.Sqrt(p*(1-p)/(l-1)))
+	return -math.Log2(pu)
+}
+
+// collisionEstimate implements 6.3.2 on a bitstring, in bits per bit
+func collisionEstimate(s []byte) float64 {
+	// with binary samples a value repeats within 2 or 3 samples, so the
+	// collision times are summed up as they are found
+	var v, sum, sumSq float64
+	for i := 0; i+1 < len(s); {
+		t := 2
+		if s[i] != s[i+1] {
+			if i+2 >= len(s) {
+				break
+			}
+			t = 3
+		}
+		i += t
+		v++
+		sum += float64(t)
+		sumSq += float64(t * t)
+	}
+	if v < 2 {
+		return 1
+	}
+
+	mean := sum / v
+	sd := math.Sqrt(max(0, (sumSq-v*mean*mean)/(v-1)))
+	x := mean - estimateZ*sd/math.Sqrt(v)
+
+	// expected collision time for P(most likely bit) = p, decreasing in p
+	expected := func(p float64) float64 {
+		q := 1 - p
+		z := 1 / q
+		f := (z*z + 2*z + 2) / (z * z * z) // F(q) = Gamma(3, z) z^-3 e^z
+		return p/(q*q)*(1+0.5*(1/p-1/q))*f - p/q*0.5*(1/p-1/q)
+	}
+	p, ok := solveDecreasing(expected, x, 0.5, 1)
+	if !ok {
+		return 1
+	}
+	return -math.Log2(p)
+}
+
+// markovEstimate implements 6.3.3 on a bitstring, in bits per bit
+func markovEstimate(s []byte) float64 {
+	var c [2][2]float64
+	ones := 0.0
+	for i, b := range s {
+		ones += float64(b)
+		if i > 0 {
+			c[s[i-1]][b]++
+		}
+	}
+	p1 := ones / float64(len(s))
+	p0 := 1 - p1
+	p00, p01 := ratio(c[0][0], c[0][0]+c[0][1]), ratio(c[0][1], c[0][0]+c[0][1])
+	p10, p11 := ratio(c[1][0], c[1][0]+c[1][1]), ratio(c[1][1], c[1][0]+c[1][1])
+
+	// log2 probabilities of the most likely 128-bit sequences
+	lg := math.Log2
+	candidates := []float64{
+		lg(p0) + 127*lg(p00),
+		lg(p0) + 64*lg(p01) + 63*lg(p10),
+		lg(p0) + lg(p01) + 126*lg(p11),
+		lg(p1) + lg(p10) + 126*lg(p00),
+		lg(p1) + 64*lg(p10) + 63*lg(p01),
+		lg(p1) + 127*lg(p11),
+	}
+	pmax := math.Inf(-1)
+	for _, c := range candidates {
+		if !math.IsNaN(c) {
+			pmax = max(pmax, c)
+		}
+	}
+	return min(-pmax/128, 1)
+}
+
+// compressionEstimate implements 6.3.4 (Maurer's universal statistic) on a
+// bitstring, in bits per bit
+func compressionEstimate(s []byte) float64 {
+	const b, d = 6, 1000
+
+	n := len(s) / b
+	v := n - d
+	if v < 2 {
+		return 1
+	}
+
+	var dict [1 << b]int
+	var sum, sumSq float64
+	for i := 1; i <= n; i++ {
+		block := 0
+		for _, bit := range s[(i-1)*b : i*b] {
+			block = block<<1 | int(bit)
+		}
+		if i > d {
+			dist := i
+			if dict[block] != 0 {
+				dist = i - dict[block]
+			}
+			l := math.Log2(float64(dist))
+			sum += l
+			sumSq += l * l
+		}
+		dict[block] = i
+	}
+	fv := float64(v)
+	mean := sum / fv
+	sd := 0.5907 * math.Sqrt(max(0, sumSq/(fv-1)-mean*mean))
+	x := mean - estimateZ*sd/math.Sqrt(fv)
+
+	// G(z) folded to O(n): the u < t terms only depend on u
+	g := func(z float64) float64 {
+		total := 0.0
+		pow := 1.0 // (1-z)^(u-1)
+		for u := 1; u <= n; u++ {
+			lu := math.Log2(float64(u))
+			if u < n {
+				total += lu * z * z * pow * float64(n-max(d, u))
+			}
+			if u > d {
+				total += lu * z * pow
+			}
+			pow *= 1 - z
+		}
+		return total / fv
+	}
+	const k = 1<<b - 1
+	expected := func(p float64) float64 {
+		return g(p) + k*g((1-p)/k)
+	}
+	p, ok := solveDecreasing(expected, x, 1.0/(1<<b), 1)
+	if !ok {
+		return 1
+	}
+	return -math.Log2(p) / b
+}
+
+// solveDecreasing finds p in [lo, hi] with f(p) = target for f decreasing
+// on that interval, false if target is out of its range
+func solveDecreasing(f func(float64) float64, target, lo, hi float64) (float64, bool) {
+	if target > f(lo) {
+		return 0, false
+	}
+	if target < f(hi-1e-12) {
+		return hi, true
+	}
+	for range 60 {
+		mid := (lo + hi) / 2
+		if f(mid) > target {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2, true
+}
+
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
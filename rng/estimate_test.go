@@ -0,0 +1,102 @@
+package rng
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// testSamples returns an estimate window of bytes from gen, with a fixed seed
+func testSamples(gen func(r *rand.Rand) byte) []byte {
+	r := rand.New(rand.NewChaCha8([32]byte{1}))
+	s := make([]byte, estimateWindow)
+	for i := range s {
+		s[i] = gen(r)
+	}
+	return s
+}
+
+// estimators lists the individual estimates, in bits per byte
+func estimators(e Estimate) map[string]float64 {
+	return map[string]float64{
+		"mcv":         e.MostCommon,
+		"collision":   e.Collision,
+		"markov":      e.Markov,
+		"compression": e.Compression,
+	}
+}
+
+func TestEstimateConstant(t *testing.T) {
+	e := EstimateMinEntropy(make([]byte, estimateWindow))
+	for name, h := range estimators(e) {
+		if h < 0 || h > 0.01 {
+			t.Errorf("%s: %v bits per byte for a constant source", name, h)
+		}
+	}
+	if e.MinEntropy != 0 || e.Samples != estimateWindow {
+		t.Errorf("estimate %+v", e)
+	}
+}
+
+func TestEstimateUniform(t *testing.T) {
+	e := EstimateMinEntropy(testSamples(func(r *rand.Rand) byte { return byte(r.Uint32()) }))
+
+	// the 99% bounds and the short window keep them below 8
+	for name, lo := range map[string]float64{"mcv": 6.5, "collision": 6, "markov": 7.5, "compression": 5.5} {
+		if h := estimators(e)[name]; h < lo || h > 8 {
+			t.Errorf("%s: %v bits per byte for a uniform source, want %v to 8", name, h, lo)
+		}
+	}
+	if e.MinEntropy < 5.5 {
+		t.Errorf("min-entropy %v for a uniform source", e.MinEntropy)
+	}
+}
+
+// TestEstimateBiased checks that no estimator credits bits that are 1 with
+// probability 3/4 with more than their min-entropy, -log2(3/4) * 8 = 3.32
+func TestEstimateBiased(t *testing.T) {
+	e := EstimateMinEntropy(testSamples(func(r *rand.Rand) byte {
+		var b byte
+		for j := range 8 {
+			if r.Float64() < 0.75 {
+				b |= 1 << j
+			}
+		}
+		return b
+	}))
+	for name, h := range estimators(e) {
+		if h < 1.5 || h > 3.6 {
+			t.Errorf("%s: %v bits per byte, want 1.5 to 3.6", name, h)
+		}
+	}
+}
+
+// TestEstimateMarkov checks that alternating bits, which have every value
+// equally often, are caught by the Markov estimate
+func TestEstimateMarkov(t *testing.T) {
+	e := EstimateMinEntropy(testSamples(func(*rand.Rand) byte { return 0x55 }))
+	if e.Markov > 0.1 {
+		t.Errorf("markov: %v bits per byte for alternating bits", e.Markov)
+	}
+	if e.MinEntropy > 0.1 {
+		t.Errorf("min-entropy %v for alternating bits", e.MinEntropy)
+	}
+}
+
+func TestEntropyEstimatorWindow(t *testing.T) {
+	e := NewEntropyEstimator()
+	e.Sample(make([]byte, estimateWindow-1))
+	if _, ok := e.Estimate(); ok {
+		t.Fatal("estimate before a full window")
+	}
+	e.Sample([]byte{0})
+	est, ok := e.Estimate()
+	if !ok || est.MinEntropy != 0 || est.Samples != estimateWindow {
+		t.Fatalf("estimate %+v (%v) of a full constant window", est, ok)
+	}
+
+	// Zeroize keeps the estimate but not the samples
+	e.Zeroize()
+	if _, ok := e.Estimate(); !ok || e.filled != 0 {
+		t.Errorf("after Zeroize: estimate %v, %d samples", ok, e.filled)
+	}
+}
@@ -0,0 +1,233 @@
+package rng
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoSource is returned by Failover.Read when every source is quarantined
+var ErrNoSource = errors.New("rng: no entropy source available")
+
+// SourceChecker is implemented by sources that can report their health
+// without being read, e.g. QRNGCard asking the driver for module status
+type SourceChecker interface {
+	Check() error
+}
+
+// SourceNamer is implemented by sources that can name where their bytes
+// currently come from
+type SourceNamer interface {
+	Name() string
+}
+
+const failoverProbeSize = 1024 // bytes read and health tested to probe a quarantined source
+
+// Failover reads from the first healthy source of an ordered list. A source
+// that fails a read or its Check is quarantined and the next one takes over;
+// quarantined sources are probed in the background and take over again once
+// a probe reads fine and passes the health tests, highest priority first.
+type Failover struct {
+	mu         sync.Mutex
+	sources    []*failoverSource
+	active     int // index of the source that served the last read, -1 if none
+	quarantine time.Duration
+	minEntropy float64 // claimed bits per byte the probe health tests use
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type failoverSource struct {
+	name     string
+	src      QRNG
+	until    time.Time // quarantined, not probed before then; zero if healthy
+	failures uint64
+	lastErr  error
+}
+
+// FailoverStatus reports the state of one source of a Failover
+type FailoverStatus struct {
+	Name        string `json:"name"`
+	Active      bool   `json:"active"`
+	Quarantined bool   `json:"quarantined"`
+	Failures    uint64 `json:"failures"`
+	LastError   string `json:"last_error,omitempty"`
+}
+
+// NewFailover returns an empty Failover that keeps a failing source out of
+// rotation for at least quarantine, and probes it every probe afterwards
+func NewFailover(quarantine, probe time.Duration) *Failover {
+	f := &Failover{
+		active:     -1,
+		quarantine: quarantine,
+		minEntropy: DefaultMinEntropy,
+		stop:       make(chan struct{}),
+	}
+	go f.probeLoop(probe)
+	return f
+}
+
+// SetMinEntropy sets the claimed min-entropy per byte the health tests of
+// a probe derive their cutoffs from
+func (f *Failover) SetMinEntropy(h float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.minEntropy = h
+}
+
+// Add appends a source, sources added first have priority
+func (f *Failover) Add(name string, src QRNG) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sources = append(f.sources, &failoverSource{name: name, src: src})
+}
+
+// Read fills p from the highest priority source that is not quarantined,
+// moving down the list as sources fail
+func (f *Failover) Read(p []byte) error {
+	for {
+		f.mu.Lock()
+		i := f.pick()
+		if i < 0 {
+			f.active = -1
+			f.mu.Unlock()
+			return ErrNoSource
+		}
+		s := f.sources[i]
+		f.mu.Unlock()
+
+		err := s.src.Read(p)
+
+		f.mu.Lock()
+		if err == nil {
+			f.active = i
+			s.until = time.Time{}
+			f.mu.Unlock()
+			return nil
+		}
+		f.fail(s, err)
+		f.mu.Unlock()
+	}
+}
+
+// Name returns the active source, which makes a Failover a SourceNamer
+func (f *Failover) Name() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.active < 0 {
+		return ""
+	}
+	return f.sources[f.active].name
+}
+
+// Status returns the state of every source, in priority order
+func (f *Failover) Status() []FailoverStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := make([]FailoverStatus, len(f.sources))
+	for i, s := range f.sources {
+		out[i] = FailoverStatus{
+			Name:        s.name,
+			Active:      i == f.active,
+			Quarantined: !s.until.IsZero(),
+			Failures:    s.failures,
+		}
+		if s.lastErr != nil {
+			out[i].LastError = s.lastErr.Error()
+		}
+	}
+	return out
+}
+
+// Quarantine takes the named source out of rotation, e.g. after it failed
+// a health test on data it already delivered
+func (f *Failover) Quarantine(name string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, s := range f.sources {
+		if s.name == name {
+			f.fail(s, err)
+		}
+	}
+}
+
+// Stop ends the background probing
+func (f *Failover) Stop() {
+	f.stopOnce.Do(func() { close(f.stop) })
+}
+
+// pick returns the first source that is not quarantined, f.mu must be held.
+// Only a successful probe lifts a quarantine.
+func (f *Failover) pick() int {
+	for i, s := range f.sources {
+		if s.until.IsZero() {
+			return i
+		}
+	}
+	return -1
+}
+
+// fail quarantines s, f.mu must be held
+func (f *Failover) fail(s *failoverSource, err error) {
+	s.failures++
+	s.lastErr = err
+	s.until = time.Now().Add(f.quarantine)
+}
+
+// probeLoop checks the healthy sources and probes the quarantined ones whose
+// quarantine is over, extending it if they still fail to read or fail the
+// health tests
+func (f *Failover) probeLoop(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	probe := make([]byte, failoverProbeSize)
+	defer clear(probe)
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+
+		f.mu.Lock()
+		sources := append([]*failoverSource(nil), f.sources...)
+		h := f.minEntropy
+		f.mu.Unlock()
+
+		for _, s := range sources {
+			f.mu.Lock()
+			quarantined := !s.until.IsZero()
+			waiting := time.Now().Before(s.until)
+			f.mu.Unlock()
+			if waiting {
+				continue
+			}
+
+			var err error
+			if c, ok := s.src.(SourceChecker); ok {
+				err = c.Check()
+			}
+			if err == nil && quarantined {
+				err = s.src.Read(probe)
+			}
+			if err == nil && quarantined {
+				err = NewHealthTests(h).Test(probe)
+			}
+
+			f.mu.Lock()
+			switch {
+			case err != nil:
+				f.fail(s, err)
+			case quarantined:
+				s.until = time.Time{} // recovered
+			}
+			f.mu.Unlock()
+		}
+	}
+}
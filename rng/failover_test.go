@@ -0,0 +1,127 @@
+package rng
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errBroken = errors.New("broken")
+
+// switchSource fails while err is set, otherwise returns the bytes
+// (i/run)*37 for i = 0, 1, ...: runs of run equal samples
+type switchSource struct {
+	mu  sync.Mutex
+	err error
+	run int
+}
+
+func (s *switchSource) set(err error, run int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err, s.run = err, run
+}
+
+func (s *switchSource) Read(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	for i := range p {
+		p[i] = byte(i / max(s.run, 1) * 37)
+	}
+	return nil
+}
+
+func newTestFailover(t *testing.T, quarantine, probe time.Duration) (*Failover, *switchSource, *switchSource) {
+	t.Helper()
+	f := NewFailover(quarantine, probe)
+	t.Cleanup(f.Stop)
+	primary, backup := &switchSource{}, &switchSource{}
+	f.Add("primary", primary)
+	f.Add("backup", backup)
+	return f, primary, backup
+}
+
+// waitFor polls cond for up to a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFailoverTakeover(t *testing.T) {
+	f, primary, backup := newTestFailover(t, time.Hour, time.Hour)
+	p := make([]byte, 64)
+
+	if err := f.Read(p); err != nil || f.Name() != "primary" {
+		t.Fatalf("read from %q: %v", f.Name(), err)
+	}
+
+	primary.set(errBroken, 0)
+	if err := f.Read(p); err != nil || f.Name() != "backup" {
+		t.Fatalf("read from %q: %v", f.Name(), err)
+	}
+	st := f.Status()
+	if !st[0].Quarantined || st[0].Failures != 1 || st[0].LastError != errBroken.Error() || !st[1].Active {
+		t.Errorf("status %+v", st)
+	}
+
+	backup.set(errBroken, 0)
+	if err := f.Read(p); !errors.Is(err, ErrNoSource) || f.Name() != "" {
+		t.Fatalf("got %v from %q, want ErrNoSource", err, f.Name())
+	}
+}
+
+// TestFailoverQuarantineNeedsProbe checks that a source does not return to
+// rotation just because its quarantine is over
+func TestFailoverQuarantineNeedsProbe(t *testing.T) {
+	f, primary, _ := newTestFailover(t, time.Millisecond, time.Hour)
+	p := make([]byte, 64)
+
+	f.Quarantine("primary", errBroken)
+	primary.set(nil, 0)
+	time.Sleep(10 * time.Millisecond)
+
+	if err := f.Read(p); err != nil || f.Name() != "backup" {
+		t.Fatalf("read from %q: %v", f.Name(), err)
+	}
+	if !f.Status()[0].Quarantined {
+		t.Error("quarantine lifted without a probe")
+	}
+}
+
+func TestFailoverRecovery(t *testing.T) {
+	f, primary, _ := newTestFailover(t, 5*time.Millisecond, time.Millisecond)
+	p := make([]byte, 64)
+
+	// a probe that reads fine but fails the health tests keeps the
+	// quarantine: runs of 4 exceed the RCT cutoff at 7 bits per byte
+	primary.set(errBroken, 0)
+	if err := f.Read(p); err != nil || f.Name() != "backup" {
+		t.Fatalf("read from %q: %v", f.Name(), err)
+	}
+	primary.set(nil, 4)
+	waitFor(t, "a failed probe", func() bool {
+		return f.Status()[0].LastError == ErrRepetitionCount.Error()
+	})
+	if err := f.Read(p); err != nil || f.Name() != "backup" {
+		t.Fatalf("read from %q: %v", f.Name(), err)
+	}
+
+	// at the configured 1 bit per byte the same output passes
+	f.SetMinEntropy(1)
+	waitFor(t, "the primary to recover", func() bool {
+		return !f.Status()[0].Quarantined
+	})
+	if err := f.Read(p); err != nil || f.Name() != "primary" {
+		t.Fatalf("read from %q: %v", f.Name(), err)
+	}
+}
@@ -0,0 +1,151 @@
+package rng
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Generator is a random bit generator as seen by handlers, the reseed loop
+// and the per-connection derivation. *DRBG implements it for every
+// registered algorithm.
+type Generator interface {
+	Instantiate(entropy, nonce, personalization []byte) error
+	Reseed(entropy, additional []byte) error
+	Generate(out, additional []byte) error
+	Zeroize()
+	Metadata() Metadata
+}
+
+// Mechanism is a raw DRBG algorithm. It is not safe for concurrent use,
+// DRBG adds locking and observability on top of it.
+type Mechanism interface {
+	Instantiate(entropy, nonce, personalization []byte) error
+	Reseed(entropy, additional []byte) error
+	Generate(out, additional []byte) error
+	Zeroize()
+}
+
+// Algorithm describes how to build and seed one registered mechanism
+type Algorithm struct {
+	New        func() Mechanism
+	NonceLen   int          // seed bytes used as nonce when instantiating children
+	MaxRequest int          // max bytes per Generate call on the mechanism
+	KAT        func() error // optional known-answer self-test
+}
+
+// DRBG algorithms registered by default
+const (
+	AlgoChaCha20   = "ChaCha20"
+	AlgoCTRAES256  = "CTR_DRBG-AES-256"
+	AlgoHMACSHA512 = "HMAC_DRBG-SHA-512"
+	AlgoHashSHA512 = "Hash_DRBG-SHA-512"
+
+	mechanismNonceLen = 16 // 128-bit nonce for the SP 800-90A mechanisms
+	seedEntropyLen    = 32 // 256-bit entropy input for children
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Algorithm{}
+)
+
+func init() {
+	Register(AlgoChaCha20, Algorithm{
+		New:        func() Mechanism { return NewChaChaDRBG() },
+		MaxRequest: chachaMaxRequest,
+		KAT:        chachaSelfTest,
+	})
+	Register(AlgoCTRAES256, Algorithm{
+		New:        func() Mechanism { return NewCTRDRBG(true) },
+		NonceLen:   mechanismNonceLen,
+		MaxRequest: ctrMaxRequest,
+		KAT:        func() error { return runKATs(AlgoCTRAES256) },
+	})
+	Register(AlgoHMACSHA512, Algorithm{
+		New:        func() Mechanism { return NewHMACDRBG() },
+		NonceLen:   mechanismNonceLen,
+		MaxRequest: hmacMaxRequest,
+		KAT:        func() error { return runKATs(AlgoHMACSHA512) },
+	})
+	Register(AlgoHashSHA512, Algorithm{
+		New:        func() Mechanism { return NewHashDRBG() },
+		NonceLen:   mechanismNonceLen,
+		MaxRequest: hashMaxRequest,
+		KAT:        func() error { return runKATs(AlgoHashSHA512) },
+	})
+}
+
+// Register makes an algorithm available by name, replacing any previous one
+func Register(name string, a Algorithm) {
+	if a.New == nil || a.MaxRequest <= 0 {
+		panic("rng: Register " + name + ": incomplete Algorithm")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = a
+}
+
+// Algorithms returns the registered algorithm names, sorted
+func Algorithms() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// lookup finds an algorithm by name, ignoring case so it can come from config
+func lookup(name string) (string, Algorithm, error) {
+	registryMu.RLock()
+	if a, ok := registry[name]; ok {
+		registryMu.RUnlock()
+		return name, a, nil
+	}
+	for n, a := range registry {
+		if strings.EqualFold(n, name) {
+			registryMu.RUnlock()
+			return n, a, nil
+		}
+	}
+	registryMu.RUnlock()
+
+	return "", Algorithm{}, fmt.Errorf("rng: unknown DRBG algorithm %q (available: %s)",
+		name, strings.Join(Algorithms(), ", "))
+}
+
+// New returns an uninstantiated generator running the named algorithm
+func New(name string) (*DRBG, error) {
+	name, a, err := lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return &DRBG{
+		mech:         a.New(),
+		nonceLen:     a.NonceLen,
+		maxRequest:   a.MaxRequest,
+		maxGenerates: DefaultMaxGenerates,
+		maxBytes:     DefaultMaxBytesPerReseed,
+		algo:         name,
+	}, nil
+}
+
+// KnownAnswerTest runs the self-test of the named algorithm, if it has one
+func KnownAnswerTest(name string) error {
+	name, a, err := lookup(name)
+	if err != nil {
+		return err
+	}
+	if a.KAT == nil {
+		return nil
+	}
+	if err := a.KAT(); err != nil {
+		return fmt.Errorf("rng: %s: %w", name, err)
+	}
+	return nil
+}
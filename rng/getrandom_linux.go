@@ -0,0 +1,64 @@
+package rng
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// getrandomMax is the most getrandom(2) returns per call without GRND_RANDOM
+const getrandomMax = 32 << 20
+
+// GetrandomSource reads the kernel CSPRNG through getrandom(2), without a
+// file descriptor to keep open or reopen
+type GetrandomSource struct {
+	flags int
+	name  string
+}
+
+// NewGetrandom returns a getrandom(2) source. random selects GRND_RANDOM,
+// the blocking pool on kernels before 5.6; nonblock selects GRND_NONBLOCK,
+// failing with EAGAIN instead of waiting for the pool to be initialized.
+func NewGetrandom(random, nonblock bool) (*GetrandomSource, error) {
+	g := &GetrandomSource{name: SourceGetrandom}
+	if random {
+		g.flags |= unix.GRND_RANDOM
+		g.name += ":random"
+	}
+	if nonblock {
+		g.flags |= unix.GRND_NONBLOCK
+		if random {
+			g.name += "+nonblock"
+		} else {
+			g.name += ":nonblock"
+		}
+	}
+	// probe once, so kernels without the syscall are rejected up front
+	var b [1]byte
+	if _, err := unix.Getrandom(b[:], g.flags|unix.GRND_NONBLOCK); err != nil && !errors.Is(err, unix.EAGAIN) {
+		return nil, fmt.Errorf("rng: getrandom: %w", err)
+	}
+	return g, nil
+}
+
+// Name returns the source spec, e.g. getrandom:random
+func (g *GetrandomSource) Name() string {
+	return g.name
+}
+
+// Read fills p, retrying interrupted and short reads. With GRND_NONBLOCK
+// an uninitialized (or, with GRND_RANDOM, exhausted) pool is an error.
+func (g *GetrandomSource) Read(p []byte) error {
+	for len(p) > 0 {
+		n, err := unix.Getrandom(p[:min(len(p), getrandomMax)], g.flags)
+		switch {
+		case errors.Is(err, unix.EINTR):
+			continue
+		case err != nil:
+			return fmt.Errorf("rng: %s: %w", g.name, err)
+		}
+		p = p[n:]
+	}
+	return nil
+}
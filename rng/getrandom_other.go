@@ -0,0 +1,16 @@
+//go:build !linux
+
+package rng
+
+import "errors"
+
+// GetrandomSource is only available on Linux
+type GetrandomSource struct{}
+
+// NewGetrandom fails outside Linux
+func NewGetrandom(random, nonblock bool) (*GetrandomSource, error) {
+	return nil, errors.New("rng: getrandom(2) is only available on Linux")
+}
+
+func (g *GetrandomSource) Name() string        { return SourceGetrandom }
+func (g *GetrandomSource) Read(p []byte) error { return ErrNoSource }
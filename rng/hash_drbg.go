@@ -0,0 +1,157 @@
+package rng
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+)
+
+// SP 800-90A Table 2 parameters for Hash_DRBG with SHA-512
+const (
+	hashOutLen         = sha512.Size
+	hashSeedLen        = 888 / 8
+	hashReseedInterval = 1 << 48
+	hashMaxRequest     = (1 << 19) / 8 // 64 KiB per Generate call
+	hashMinEntropy     = 32            // 256-bit security strength
+)
+
+// HashDRBG is an SP 800-90A Rev. 1 Hash_DRBG instantiated with SHA-512.
+// It is not safe for concurrent use; DRBG wraps it with its own mutex.
+type HashDRBG struct {
+	v             [hashSeedLen]byte
+	c             [hashSeedLen]byte
+	reseedCounter uint64
+	instantiated  bool
+}
+
+// NewHashDRBG returns an uninstantiated Hash_DRBG
+func NewHashDRBG() *HashDRBG {
+	return &HashDRBG{}
+}
+
+// Instantiate implements Hash_DRBG_Instantiate_algorithm (10.1.1.2)
+func (h *HashDRBG) Instantiate(entropy, nonce, personalization []byte) error {
+	if len(entropy) < hashMinEntropy {
+		return ErrEntropyLength
+	}
+
+	hashDF(h.v[:], entropy, nonce, personalization)
+	hashDF(h.c[:], []byte{0x00}, h.v[:])
+	h.reseedCounter = 1
+	h.instantiated = true
+	return nil
+}
+
+// Reseed implements Hash_DRBG_Reseed_algorithm (10.1.1.3)
+func (h *HashDRBG) Reseed(entropy, additional []byte) error {
+	if !h.instantiated {
+		return ErrNotInstantiated
+	}
+	if len(entropy) < hashMinEntropy {
+		return ErrEntropyLength
+	}
+
+	var v [hashSeedLen]byte
+	hashDF(v[:], []byte{0x01}, h.v[:], entropy, additional)
+	h.v = v
+	clear(v[:])
+	hashDF(h.c[:], []byte{0x00}, h.v[:])
+	h.reseedCounter = 1
+	return nil
+}
+
+// Generate implements Hash_DRBG_Generate_algorithm (10.1.1.4) and fills out,
+// which may be at most 64 KiB long.
+func (h *HashDRBG) Generate(out, additional []byte) error {
+	if !h.instantiated {
+		return ErrNotInstantiated
+	}
+	if len(out) > hashMaxRequest {
+		return ErrRequestTooLarge
+	}
+	if h.reseedCounter > hashReseedInterval {
+		return ErrReseedRequired
+	}
+
+	d := sha512.New()
+	var sum [hashOutLen]byte
+
+	if len(additional) > 0 {
+		d.Write([]byte{0x02})
+		d.Write(h.v[:])
+		d.Write(additional)
+		hashAdd(h.v[:], d.Sum(sum[:0]))
+	}
+
+	// Hashgen (10.1.1.4)
+	data := h.v
+	for off := 0; off < len(out); off += hashOutLen {
+		d.Reset()
+		d.Write(data[:])
+		copy(out[off:], d.Sum(sum[:0]))
+		hashAdd(data[:], []byte{0x01})
+	}
+	clear(data[:])
+
+	d.Reset()
+	d.Write([]byte{0x03})
+	d.Write(h.v[:])
+	hashAdd(h.v[:], d.Sum(sum[:0]))
+	hashAdd(h.v[:], h.c[:])
+	var rc [8]byte
+	binary.BigEndian.PutUint64(rc[:], h.reseedCounter)
+	hashAdd(h.v[:], rc[:])
+	clear(sum[:])
+
+	h.reseedCounter++
+	return nil
+}
+
+// ReseedCounter returns the SP 800-90A reseed counter
+func (h *HashDRBG) ReseedCounter() uint64 {
+	return h.reseedCounter
+}
+
+// Zeroize wipes the working state and leaves the DRBG uninstantiated
+func (h *HashDRBG) Zeroize() {
+	clear(h.v[:])
+	clear(h.c[:])
+	h.reseedCounter = 0
+	h.instantiated = false
+}
+
+// hashDF implements Hash_df (10.3.1), filling out with len(out)*8 bits
+// derived from the concatenation of input.
+func hashDF(out []byte, input ...[]byte) {
+	d := sha512.New()
+	var sum [hashOutLen]byte
+	var bits [4]byte
+	binary.BigEndian.PutUint32(bits[:], uint32(len(out)*8))
+
+	counter := byte(1)
+	for off := 0; off < len(out); off += hashOutLen {
+		d.Reset()
+		d.Write([]byte{counter})
+		d.Write(bits[:])
+		for _, in := range input {
+			d.Write(in)
+		}
+		copy(out[off:], d.Sum(sum[:0]))
+		counter++
+	}
+	clear(sum[:])
+}
+
+// hashAdd sets v = (v + x) mod 2^(8*len(v)), both big-endian
+func hashAdd(v, x []byte) {
+	var carry uint16
+	j := len(x) - 1
+	for i := len(v) - 1; i >= 0; i-- {
+		sum := uint16(v[i]) + carry
+		if j >= 0 {
+			sum += uint16(x[j])
+			j--
+		}
+		v[i] = byte(sum)
+		carry = sum >> 8
+	}
+}
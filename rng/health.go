@@ -0,0 +1,132 @@
+package rng
+
+import (
+	"errors"
+	"math"
+)
+
+// SP 800-90B section 4.4 continuous health tests on 8-bit samples
+const (
+	healthAlphaLog2   = 20  // false positive probability alpha = 2^-20
+	aptWindow         = 512 // non-binary sources
+	DefaultMinEntropy = 7.0 // claimed bits of min-entropy per byte
+)
+
+// Health test failures, the offending block is rejected
+var (
+	ErrRepetitionCount    = errors.New("rng: repetition count test failed")
+	ErrAdaptiveProportion = errors.New("rng: adaptive proportion test failed")
+)
+
+// HealthTests runs the Repetition Count Test and the Adaptive Proportion
+// Test over a continuous stream of bytes. It is not safe for concurrent use.
+type HealthTests struct {
+	minEntropy float64
+	rctCutoff  int
+	aptCutoff  int
+
+	// RCT state: current sample and how many times in a row it was seen
+	rctLast byte
+	rctRun  int
+
+	// APT state: first sample of the window, its count, samples seen
+	aptFirst byte
+	aptCount int
+	aptSeen  int
+
+	rctFailures uint64
+	aptFailures uint64
+}
+
+// NewHealthTests returns tests with cutoffs derived from the claimed
+// min-entropy per byte h, 0 < h <= 8
+func NewHealthTests(h float64) *HealthTests {
+	if h <= 0 || h > 8 {
+		h = DefaultMinEntropy
+	}
+	return &HealthTests{
+		minEntropy: h,
+		rctCutoff:  RCTCutoff(h),
+		aptCutoff:  APTCutoff(h),
+	}
+}
+
+// RCTCutoff is C = 1 + ceil(-log2(alpha) / H) (SP 800-90B 4.4.1)
+func RCTCutoff(h float64) int {
+	return 1 + int(math.Ceil(healthAlphaLog2/h))
+}
+
+// APTCutoff is C = 1 + CRITBINOM(W, 2^-H, 1 - alpha) (SP 800-90B 4.4.2),
+// the smallest count the most common sample exceeds with probability alpha
+func APTCutoff(h float64) int {
+	p := math.Exp2(-h)
+	target := 1 - math.Exp2(-healthAlphaLog2)
+
+	// cumulative binomial, terms computed in log space
+	cdf := 0.0
+	for k := 0; k <= aptWindow; k++ {
+		lg := lgammaInt(aptWindow+1) - lgammaInt(k+1) - lgammaInt(aptWindow-k+1) +
+			float64(k)*math.Log(p) + float64(aptWindow-k)*math.Log1p(-p)
+		cdf += math.Exp(lg)
+		if cdf >= target {
+			return 1 + k
+		}
+	}
+	return aptWindow
+}
+
+func lgammaInt(n int) float64 {
+	v, _ := math.Lgamma(float64(n))
+	return v
+}
+
+// Cutoffs returns the RCT and APT cutoffs in use
+func (t *HealthTests) Cutoffs() (rct, apt int) {
+	return t.rctCutoff, t.aptCutoff
+}
+
+// Test feeds block through both tests and returns the first failure. The
+// state is reset after a failure, the caller is expected to drop the block.
+func (t *HealthTests) Test(block []byte) error {
+	for _, b := range block {
+		// Repetition Count Test
+		if t.rctRun > 0 && b == t.rctLast {
+			t.rctRun++
+			if t.rctRun >= t.rctCutoff {
+				t.rctFailures++
+				t.Reset()
+				return ErrRepetitionCount
+			}
+		} else {
+			t.rctLast, t.rctRun = b, 1
+		}
+
+		// Adaptive Proportion Test
+		if t.aptSeen == 0 {
+			t.aptFirst, t.aptCount = b, 1
+		} else if b == t.aptFirst {
+			t.aptCount++
+			if t.aptCount >= t.aptCutoff {
+				t.aptFailures++
+				t.Reset()
+				return ErrAdaptiveProportion
+			}
+		}
+		if t.aptSeen++; t.aptSeen == aptWindow {
+			t.aptSeen = 0
+		}
+	}
+	return nil
+}
+
+// Reset starts both tests afresh, e.g. after switching sources
+func (t *HealthTests) Reset() {
+	t.rctRun = 0
+	t.aptSeen = 0
+	t.aptCount = 0
+}
+
+// Failures returns how many times each test failed
+func (t *HealthTests) Failures() (rct, apt uint64) {
+	return t.rctFailures, t.aptFailures
+}
@@ -0,0 +1,172 @@
+package rng
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestHealthCutoffs pins the cutoffs for alpha = 2^-20 and a 512 sample
+// window, the APT ones cross-checked with an exact binomial
+func TestHealthCutoffs(t *testing.T) {
+	for _, tc := range []struct {
+		h        float64
+		rct, apt int
+	}{
+		{1, 21, 311},
+		{2, 11, 177},
+		{4, 6, 62},
+		{7, 4, 18},
+		{8, 4, 13},
+	} {
+		rct, apt := NewHealthTests(tc.h).Cutoffs()
+		if rct != tc.rct || apt != tc.apt {
+			t.Errorf("H = %v: cutoffs RCT %d APT %d, want %d %d", tc.h, rct, apt, tc.rct, tc.apt)
+		}
+	}
+
+	// out of range claims fall back to the default
+	for _, h := range []float64{0, -1, 9} {
+		if got := NewHealthTests(h).minEntropy; got != DefaultMinEntropy {
+			t.Errorf("H = %v: min-entropy %v, want %v", h, got, DefaultMinEntropy)
+		}
+	}
+}
+
+// varied returns n bytes in which every value appears at most twice per
+// window and never twice in a row
+func varied(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 37)
+	}
+	return b
+}
+
+func TestHealthRCT(t *testing.T) {
+	for _, h := range []float64{1, 4, DefaultMinEntropy} {
+		ht := NewHealthTests(h)
+		rct, _ := ht.Cutoffs()
+
+		// one repeat short of the cutoff passes, between varied samples
+		block := append(varied(16), make([]byte, rct-1)...)
+		block = append(block, varied(16)[1:]...)
+		if err := ht.Test(block); err != nil {
+			t.Fatalf("H = %v: %d repeats: %v", h, rct-1, err)
+		}
+		if err := ht.Test(make([]byte, rct)); !errors.Is(err, ErrRepetitionCount) {
+			t.Fatalf("H = %v: %d repeats: got %v, want ErrRepetitionCount", h, rct, err)
+		}
+		if r, a := ht.Failures(); r != 1 || a != 0 {
+			t.Errorf("H = %v: failures RCT %d APT %d", h, r, a)
+		}
+	}
+}
+
+func TestHealthAPT(t *testing.T) {
+	for _, h := range []float64{4, DefaultMinEntropy, 8} {
+		ht := NewHealthTests(h)
+		_, apt := ht.Cutoffs()
+
+		// a window in which the first sample appears count times, never
+		// twice in a row
+		window := func(count int) []byte {
+			b := varied(aptWindow)
+			for i := range b {
+				if b[i] == 0 {
+					b[i] = 1
+				}
+			}
+			for i := 0; i < count; i++ {
+				b[2*i] = 0
+			}
+			return b
+		}
+
+		// the count restarts with every window
+		for range 3 {
+			if err := ht.Test(window(apt - 1)); err != nil {
+				t.Fatalf("H = %v: %d in a window: %v", h, apt-1, err)
+			}
+		}
+		if err := ht.Test(window(apt)); !errors.Is(err, ErrAdaptiveProportion) {
+			t.Fatalf("H = %v: %d in a window: got %v, want ErrAdaptiveProportion", h, apt, err)
+		}
+		if r, a := ht.Failures(); r != 0 || a != 1 {
+			t.Errorf("H = %v: failures RCT %d APT %d", h, r, a)
+		}
+	}
+}
+
+func TestHealthStuckSource(t *testing.T) {
+	ht := NewHealthTests(DefaultMinEntropy)
+	rct, _ := ht.Cutoffs()
+
+	block := make([]byte, 1024)
+	for i := range block {
+		block[i] = 0xa5
+	}
+	if err := ht.Test(block); !errors.Is(err, ErrRepetitionCount) {
+		t.Fatalf("constant block: got %v, want ErrRepetitionCount", err)
+	}
+	// the state is reset, a stuck source keeps failing
+	if err := ht.Test(block[:rct]); !errors.Is(err, ErrRepetitionCount) {
+		t.Fatalf("second constant block: got %v, want ErrRepetitionCount", err)
+	}
+}
+
+// stuckSource is a named source stuck at one value that records quarantines
+type stuckSource struct {
+	quarantined []error
+}
+
+func (s *stuckSource) Read(p []byte) error {
+	for i := range p {
+		p[i] = 0xff
+	}
+	return nil
+}
+
+func (s *stuckSource) Name() string { return "stuck" }
+
+func (s *stuckSource) Quarantine(name string, err error) {
+	s.quarantined = append(s.quarantined, err)
+}
+
+// TestHealthQuarantine checks that a buffer quarantines its source after
+// healthFailLimit failing blocks in a row, and not before
+func TestHealthQuarantine(t *testing.T) {
+	src := &stuckSource{}
+	q := &QRNGBuffer{
+		src:        src,
+		health:     NewHealthTests(DefaultMinEntropy),
+		estimators: make(map[string]*EntropyEstimator),
+	}
+	block := make([]byte, 64)
+
+	for i := 1; i < healthFailLimit; i++ {
+		if q.healthy(block) {
+			t.Fatal("constant block passed")
+		}
+		if st := q.Stats(); st.Health != HealthDegraded || len(src.quarantined) != 0 {
+			t.Fatalf("after %d failures: health %s, %d quarantines", i, st.Health, len(src.quarantined))
+		}
+	}
+	if q.healthy(block) {
+		t.Fatal("constant block passed")
+	}
+	st := q.Stats()
+	if st.Health != HealthFailed || st.RejectedBlocks != healthFailLimit || st.SourceError == "" {
+		t.Errorf("stats %+v", st)
+	}
+	if len(src.quarantined) != 1 || !errors.Is(src.quarantined[0], ErrRepetitionCount) {
+		t.Fatalf("quarantines %v", src.quarantined)
+	}
+
+	// one good block clears the failure streak
+	if !q.healthy(varied(64)) {
+		t.Fatal("varied block rejected")
+	}
+	if st := q.Stats(); st.Health != HealthOK {
+		t.Errorf("health %s after a good block", st.Health)
+	}
+}
@@ -0,0 +1,140 @@
+package rng
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"hash"
+)
+
+// SP 800-90A Table 2 parameters for HMAC_DRBG with SHA-512
+const (
+	hmacOutLen         = sha512.Size
+	hmacReseedInterval = 1 << 48
+	hmacMaxRequest     = (1 << 19) / 8 // 64 KiB per Generate call
+	hmacMinEntropy     = 32            // 256-bit security strength
+)
+
+// HMACDRBG is an SP 800-90A Rev. 1 HMAC_DRBG instantiated with SHA-512.
+// It is not safe for concurrent use; DRBG wraps it with its own mutex.
+type HMACDRBG struct {
+	key           [hmacOutLen]byte
+	v             [hmacOutLen]byte
+	mac           hash.Hash
+	reseedCounter uint64
+	instantiated  bool
+}
+
+// NewHMACDRBG returns an uninstantiated HMAC_DRBG
+func NewHMACDRBG() *HMACDRBG {
+	return &HMACDRBG{}
+}
+
+// Instantiate implements HMAC_DRBG_Instantiate_algorithm (10.1.2.3)
+func (h *HMACDRBG) Instantiate(entropy, nonce, personalization []byte) error {
+	if len(entropy) < hmacMinEntropy {
+		return ErrEntropyLength
+	}
+
+	for i := range h.key {
+		h.key[i] = 0x00
+		h.v[i] = 0x01
+	}
+	h.setKey()
+	h.update(entropy, nonce, personalization)
+	h.reseedCounter = 1
+	h.instantiated = true
+	return nil
+}
+
+// Reseed implements HMAC_DRBG_Reseed_algorithm (10.1.2.4)
+func (h *HMACDRBG) Reseed(entropy, additional []byte) error {
+	if !h.instantiated {
+		return ErrNotInstantiated
+	}
+	if len(entropy) < hmacMinEntropy {
+		return ErrEntropyLength
+	}
+
+	h.update(entropy, additional)
+	h.reseedCounter = 1
+	return nil
+}
+
+// Generate implements HMAC_DRBG_Generate_algorithm (10.1.2.5) and fills out,
+// which may be at most 64 KiB long.
+func (h *HMACDRBG) Generate(out, additional []byte) error {
+	if !h.instantiated {
+		return ErrNotInstantiated
+	}
+	if len(out) > hmacMaxRequest {
+		return ErrRequestTooLarge
+	}
+	if h.reseedCounter > hmacReseedInterval {
+		return ErrReseedRequired
+	}
+
+	if len(additional) > 0 {
+		h.update(additional)
+	}
+
+	for off := 0; off < len(out); off += hmacOutLen {
+		h.mac.Reset()
+		h.mac.Write(h.v[:])
+		h.mac.Sum(h.v[:0])
+		copy(out[off:], h.v[:])
+	}
+
+	h.update(additional)
+	h.reseedCounter++
+	return nil
+}
+
+// ReseedCounter returns the SP 800-90A reseed counter
+func (h *HMACDRBG) ReseedCounter() uint64 {
+	return h.reseedCounter
+}
+
+// Zeroize wipes the working state and leaves the DRBG uninstantiated
+func (h *HMACDRBG) Zeroize() {
+	clear(h.key[:])
+	clear(h.v[:])
+	if h.mac != nil {
+		h.mac.Reset()
+	}
+	h.mac = nil
+	h.reseedCounter = 0
+	h.instantiated = false
+}
+
+// update implements HMAC_DRBG_Update (10.1.2.2), provided data being the
+// concatenation of all parts.
+func (h *HMACDRBG) update(provided ...[]byte) {
+	empty := true
+	for _, p := range provided {
+		if len(p) > 0 {
+			empty = false
+		}
+	}
+
+	for _, sep := range []byte{0x00, 0x01} {
+		h.mac.Reset()
+		h.mac.Write(h.v[:])
+		h.mac.Write([]byte{sep})
+		for _, p := range provided {
+			h.mac.Write(p)
+		}
+		h.mac.Sum(h.key[:0])
+		h.setKey()
+
+		h.mac.Write(h.v[:])
+		h.mac.Sum(h.v[:0])
+
+		if empty {
+			return
+		}
+	}
+}
+
+func (h *HMACDRBG) setKey() {
+	h.mac = hmac.New(sha512.New, h.key[:])
+}
@@ -0,0 +1,299 @@
+package rng
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// drbgKAT is a known-answer vector in CAVP/ACVP layout: instantiate,
+// optional reseed, two generate calls, compare the output of the second one.
+// With prediction resistance (entropyPR1 set) each generate call is preceded
+// by a reseed with its entropy and additional input, as in the pr_true files.
+type drbgKAT struct {
+	name           string
+	algo           string
+	mech           func() Mechanism
+	entropy        string
+	nonce          string
+	personal       string
+	entropyReseed  string
+	additionReseed string
+	addition1      string
+	addition2      string
+	entropyPR1     string
+	entropyPR2     string
+	returned       string
+}
+
+var drbgKATs = []drbgKAT{
+	{
+		// CAVP drbgvectors_no_reseed/CTR_DRBG.rsp [AES-256 use df] COUNT = 0
+		name:     "CAVP AES-256 use df",
+		algo:     AlgoCTRAES256,
+		mech:     func() Mechanism { return NewCTRDRBG(true) },
+		entropy:  "36401940fa8b1fba91a1661f211d78a0b9389a74e5bccfece8d766af1a6d3b14",
+		nonce:    "496f25b0f1301b4f501be30380a137eb",
+		returned: "5862eb38bd558dd978a696e6df164782ddd887e7e9a6c9f3f1fbafb78941b535a64912dfd224c6dc7454e5250b3d97165e16260c2faf1cc7735cb75fb4f07e1d",
+	},
+	{
+		// CAVP drbgvectors_pr_false/CTR_DRBG.rsp [AES-256 use df]
+		// [PersonalizationStringLen = 256] [AdditionalInputLen = 256] COUNT = 0
+		name:           "CAVP AES-256 use df reseed",
+		algo:           AlgoCTRAES256,
+		mech:           func() Mechanism { return NewCTRDRBG(true) },
+		entropy:        "174b46250051a9e3d80c56ae7163dafe7e54481a56cafd3b8625f99bbb29c442",
+		nonce:          "98ffd99c466e0e94a45da7e0e82dbc6b",
+		personal:       "7095268e99938b3e042734b9176c9aa051f00a5f8d2a89ada214b89beef18ebf",
+		entropyReseed:  "e88be1967c5503f65d23867bbc891bd679db03b4878663f6c877592df25f0d9a",
+		additionReseed: "cdf6ad549e45b6aa5cd67d024931c33cd133d52d5ae500c3015020beb30da063",
+		addition1:      "c7228e90c62f896a09e11684530102f926ec90a3255f6c21b857883c75800143",
+		addition2:      "76a94f224178fe4cbf9e2b8acc53c9dc3e50bb613aac8936601453cda3293b17",
+		returned: "1a6d8dbd642076d13916e5e23038b60b26061f13dd4e006277e0268698ffb2c87e453bae1251631ac90c701a9849d933" +
+			"995e8b0221fe9aca1985c546c2079027",
+	},
+	{
+		// ACVP-Server gen-val/json-files/ctrDRBG-1.0/prompt.json, AES-256 no df
+		name:           "ACVP AES-256 no df",
+		algo:           AlgoCTRAES256,
+		mech:           func() Mechanism { return NewCTRDRBG(false) },
+		entropy:        "9fcbb4ccc0135c484bded061da9fd70748682fe84166b97ff53f9aa1909b2e95d3d529c0f453b3ac575d12aa441cc5cd",
+		personal:       "2c9fed0b39556cdbe699ebca2a0ec7eecb287e8744475050c572fa8ae9ed0a4a7d6f1cabf1c4278532fb20af7d64bd32",
+		entropyReseed:  "913c0da19b010eddd55a7a4f3f713eef5b1534d34360a7ec376ae71a6b340043cc7726f762cb853453f399b3a645062a",
+		additionReseed: "2d9d4ec141a22e6cd2f6ee4f6719cf6bdf95cfe50b8d5ea6c87d38b4b872706fff80b0380bb90e9c42d11d6526e56c29",
+		addition1:      "a642f06d327828f3e84564a3e37d60c157073b95864ca07981b0189668a0d978cd5dc68f06801ceff0dc839a312b028e",
+		addition2:      "9db14babfa9107c88ba92073c0b4a65e89147ea06d74b894142979482f452915b35b5636f9b8a951759735ade7c8d5d1",
+		returned: "f10c645683ff0131254052ed4c698122b46b563654c29d728ac191ca4aaefe649eefe4c6fc33b25bb739294dd5cf5780" +
+			"99f856c98d98000cbf971f1e6ea900822ff8c110118f6520471744d3f8a3f5c7d568494240e57f5488af9c9f9f4e7322" +
+			"f56ccd843c0dbfce9170c02e205389420527f23edb3369d9fcc5e34901b5ba4eb71b973fc7982ffe0899ff7fe53ee0c4" +
+			"f51a3ef93ef9c6d4d279dd7536f8776be94aaa05e89ef6e6aee8832b4b42ffca5fb91ec0273f9ef945865512889b0c5e" +
+			"e141d1b38df827d2a694835561628c6f9b093a01a835f07adbb9e03febf93389e8f3b86e1e0abf1f9958fa286ad99528" +
+			"9c2f606d1a9043a166c1afe8d00769c712650819c9068a4bd22717c98338395a7ba6e95b5178bfbf4efb0f05a91713ba" +
+			"8bf2127a6ba1edfa6d1cab05c03ee0d2afe1da4eb8f2c579ec872ff4b602027ef4bdcf2f4b01423f8e600a13d7cacb6a" +
+			"b83263ba58f907694af614a6724fd0e4c627a0d91ddc6716c697face6f4808a4f37b731de4e0cd4766ceadaaaf479925" +
+			"05299c72ac1a6e9a8335b8d7e501b3841188d0da4de5267674444dc2b0cf9f010756fa865a25ca3f1b24c34e845b2259" +
+			"926b6a867a7684de68a6137c4fb0f47a2e54ae9e6455beba0b0a9629644fe9e378ee95386443ba977124ffd1192e9f46" +
+			"0684c7b09fa99f5f93f04f56fd7955e042187887ce696f1934017e458b16b5c9",
+	},
+	{
+		// CAVP drbgvectors_no_reseed/HMAC_DRBG.rsp [SHA-512] COUNT = 0
+		name:    "CAVP HMAC SHA-512",
+		algo:    AlgoHMACSHA512,
+		mech:    func() Mechanism { return NewHMACDRBG() },
+		entropy: "35049f389a33c0ecb1293238fd951f8ffd517dfde06041d32945b3e26914ba15",
+		nonce:   "f7328760be6168e6aa9fb54784989a11",
+		returned: "e76491b0260aacfded01ad39fbf1a66a88284caa5123368a2ad9330ee48335e3c9c9ba90e6cbc9429962d60c1a6661ed" +
+			"cfaa31d972b8264b9d4562cf18494128a092c17a8da6f3113e8a7edfcd4427082bd390675e9662408144971717303d8d" +
+			"c352c9e8b95e7f35fa2ac9f549b292bc7c4bc7f01ee0a577859ef6e82d79ef23892d167c140d22aac32b64ccdfeee273" +
+			"0528a38763b24227f91ac3ffe47fb11538e435307e77481802b0f613f370ffb0dbeab774fe1efbb1a80d01154a9459e7" +
+			"3ad361108bbc86b0914f095136cbe634555ce0bb263618dc5c367291ce0825518987154fe9ecb052b3f0a256fcc30cc1" +
+			"4572531c9628973639beda456f2bddf6",
+	},
+	{
+		// CAVP drbgvectors_pr_true/HMAC_DRBG.rsp [SHA-512] with
+		// personalization and additional input, COUNT = 0
+		name:       "CAVP HMAC SHA-512 prediction resistance",
+		algo:       AlgoHMACSHA512,
+		mech:       func() Mechanism { return NewHMACDRBG() },
+		entropy:    "3aca6b55561521007c9ece085e9a6635e346fa804335d6ad42ebd6814c017fa8",
+		nonce:      "aa7fd3c3dd5d03d9b8efc7f70574581f",
+		personal:   "4bc9a485ec840d377ae4504aa1df41e444c4231687f3d7851c26c275bc687463",
+		addition1:  "b39c43539fdc24343085cbb65b8d36c54732476d781104c355c391a951313a30",
+		entropyPR1: "4cc19fae5a456f8a53a656d23a0b665d6ddf7f43020a5febbb552714e447565d",
+		addition2:  "b6850edd4622675ef5a507eab911e249d63fcf62f330cc8a16bb2ccc5858de5d",
+		entropyPR2: "637386b3ab33f78fd9751c7b7e67e1e15f6e50ddc548a1eb5813f6d0d48381bf",
+		returned: "546664042bef33064da28a5718f2c2e5f72d7725e3fbe87ad2ee90fbfe6c114ed36440fbbccf29698b4360bc4ad74650" +
+			"de13825838106adc53002bc389ee900691649b972f3187b84d05cecc8fd034497dd99c6c997d1914b4ef838d84abf23f" +
+			"ae7f3ac9efdcdc04c003ac642c5126b00f9f24bf1431a4f19ef0b5f3d230aab3fdf091ba31b7ddcacdf2566f2cfab30f" +
+			"55b3123e733829b697b7c8b248420ab98ba6f11b017175256368e8d8361102c9e6d57386becbeabda092dd57aec65bc2" +
+			"0ebee78eea7294571e168c454066d256b81bb8b7bb469207a18ebedbb4348fbe97a4d86d2bd095c41f6de59aa0800e13" +
+			"1e98181886a2633cdcc550914d83b327",
+	},
+	{
+		// CAVP drbgvectors_pr_false/HMAC_DRBG.rsp [SHA-512]
+		// [PersonalizationStringLen = 256] [AdditionalInputLen = 256] COUNT = 0
+		name:           "CAVP HMAC SHA-512 reseed",
+		algo:           AlgoHMACSHA512,
+		mech:           func() Mechanism { return NewHMACDRBG() },
+		entropy:        "da740cbc36057a8e282ae717fe7dfbb245e9e5d49908a0119c5dbcf0a1f2d5ab",
+		nonce:          "46561ff612217ba3ff91baa06d4b5440",
+		personal:       "fc227293523ecb5b1e28c87863626627d958acc558a672b148ce19e2abd2dde4",
+		entropyReseed:  "1d61d4d8a41c3254b92104fd555adae0569d1835bb52657ec7fbba0fe03579c5",
+		additionReseed: "b9ed8e35ad018a375b61189c8d365b00507cb1b4510d21cac212356b5bbaa8b2",
+		addition1:      "b7998998eaf9e5d34e64ff7f03de765b31f407899d20535573e670c1b402c26a",
+		addition2:      "2089d49d63e0c4df58879d0cb1ba998e5b3d1a7786b785e7cf13ca5ea5e33cfd",
+		returned: "5b70f3e4da95264233efbab155b828d4e231b67cc92757feca407cc9615a660871cb07ad1a2e9a99412feda8ee34dc9c" +
+			"57fa08d3f8225b30d29887d20907d12330fffd14d1697ba0756d37491b0a8814106e46c8677d49d9157109c402ad0c24" +
+			"7a2f50cd5d99e538c850b906937a05dbb8888d984bc77f6ca00b0e3bc97b16d6d25814a54aa12143afddd8b226369056" +
+			"5d545f4137e593bb3ca88a37b0aadf79726b95c61906257e6dc47acd5b6b7e4b534243b13c16ad5a0a1163c0099fce43" +
+			"f428cd27c3e6463cf5e9a9621f4b3d0b3d4654316f4707675df39278d5783823049477dcce8c57fdbd576711c91301e9" +
+			"bd6bb0d3e72dc46d480ed8f61fd63811",
+	},
+	{
+		// CAVP drbgvectors_no_reseed/Hash_DRBG.rsp [SHA-512] COUNT = 0
+		name:    "CAVP Hash SHA-512",
+		algo:    AlgoHashSHA512,
+		mech:    func() Mechanism { return NewHashDRBG() },
+		entropy: "6b50a7d8f8a55d7a3df8bb40bcc3b722d8708de67fda010b03c4c84d72096f8c",
+		nonce:   "3ec649cc6256d9fa31db7a2904aaf025",
+		returned: "95b7f17e9802d3577392c6a9c08083b67dd1292265b5f42d237f1c55bb9b10bfcfd82c77a378b8266a0099143b3c2d64" +
+			"611eeeb69acdc055957c139e8b190c7a06955f2c797c2778de940396a501f40e91396acf8d7e45ebdbb53bbf8c975230" +
+			"d2f0ff9106c76119ae498e7fbc03d90f8e4c51627aed5c8d4263d5d2b978873a0de596ee6dc7f7c29e37eee8b34c90dd" +
+			"1cf6a9ddb22b4cbd086b14b35de93da2d5cb1806698cbd7bbb67bfe3d31fd2d1dbd2a1e058a3eb99d7e51f1a938eed5e" +
+			"1c1de23a6b4345d3191409f92f39b3670d8dbfb635d8e6a36932d81033d1448d63b403ddf88e121b6e819ac381226c13" +
+			"21e4b08644f6727c368c5a9f7a4b3ee2",
+	},
+	{
+		// CAVP drbgvectors_pr_false/Hash_DRBG.rsp [SHA-512]
+		// [PersonalizationStringLen = 256] [AdditionalInputLen = 256] COUNT = 0
+		name:           "CAVP Hash SHA-512 reseed",
+		algo:           AlgoHashSHA512,
+		mech:           func() Mechanism { return NewHashDRBG() },
+		entropy:        "4b23595b0a3640cfabb0ec34df6a613308b0448488a5d9ff99da4278e072eb34",
+		nonce:          "8e696bffd9ca3a71d2e2f05e600c8364",
+		personal:       "010ba93ea68a3d4a200e5145859e299c5b5349b7645fb5bbcad687aba7d67313",
+		entropyReseed:  "04de4babdbe143bde99aa4452f9aa43b0a164eb927555c0496aa0fc9328a521c",
+		additionReseed: "2b0c7c3efb36b71b917a44086d168313675b426b17c5ab3d0eb6af753f6040e0",
+		addition1:      "d0b7d1d12ab15d3bba8f4eba07fee0974838962b247be480683b8e3d4a91033a",
+		addition2:      "66c78ca12e45bdca003b49cb6440b977dd85b167e7c803890ed1a73666eaa869",
+		returned: "4008cbd8281dc82fd6c368f650ef2609bb771e80c63d478a77fa938248dcbb8b79e54ead0265f6ff1ebfafe4e387c6e2" +
+			"7df9f03e4a5225e86a4436e56ebf03b3be2cfbcb49c89c92ec1dfa5ee445dd4f6f64e02a2423a0b18ebd02eec52f5cc2" +
+			"1bc3565e796b3ded6552f1b5a574a201c3b11018222806f9618d23d77fd02db879cf87fe24ed7ba11b3b108b559633db" +
+			"1f95c5121b28011aa4dd20399bd4978e1f8b8880c333a47ff1750679bf28d329347b26d347aae90ee562ae8029579cbe" +
+			"0336e066d6b8ba5e0169fec804c30189a4434c1bf8a5b0a249951d3d89554da38ff0751b8b1fef9ae18a0aa2bc477736" +
+			"d199a06f61d400039a4cc03869bb10ca",
+	},
+	{
+		// CAVP drbgvectors_pr_true/Hash_DRBG.rsp [SHA-512]
+		// [PersonalizationStringLen = 256] [AdditionalInputLen = 256] COUNT = 0
+		name:       "CAVP Hash SHA-512 prediction resistance",
+		algo:       AlgoHashSHA512,
+		mech:       func() Mechanism { return NewHashDRBG() },
+		entropy:    "0cfbae94684321e071e66da09e0410128b7a14ff1692014c98fac7f384e8362f",
+		nonce:      "3a217aec700c191b05e4a11803d5f651",
+		personal:   "e638d92f217337982258337ae63c3b07b30fc5668e8c123ee0e530fc0187f251",
+		addition1:  "30580498cac41c4a514dd913131dd0ef932dfc246710ffb09b33c952fe5e7e69",
+		entropyPR1: "fa65a1561e9699272c147531b29015053ea0e504c1978cc791fd75eaff51c005",
+		addition2:  "ce49a8abe2d1b66582eb54c66022fc856324cfd8c9504077b063f3d58e832fa3",
+		entropyPR2: "7cb7d7de34f568cd8e5808226db8f414f1da556cf00796dc12ecdd8ad049b1c3",
+		returned: "174df659adf93390e0543d04a55d1c23ef5faf8b1a49d36b6010a0ebdd2f77b35b5a8cf942236619a4304b5c0eaad098" +
+			"ca977a219f2c327f49b5ee4d2a29f48ec91623ce288930b8fe9092ff8ce4aee8e90f6ae9cbf8514d1636f8bda841b49a" +
+			"a3043605e49581c42f2e54a3b9f1dbe51e78996314df8e3cb43687721d1c660b37f118331dcf8b0acec110489741df45" +
+			"f2cd5c2aa2a989cd3635fb52ec156b855307e30e34092eff182886470ee9ce0df25c54ddcfe1ca1ae713a2063dc16274" +
+			"fb36dd957a3a3fcc80d2a0e908e0e43cd0d55e4241afc3ebf6f36248528f0dcfa780aa417f3dabdb7be6622c0c8c8df5" +
+			"973392c913c777454c393587e050afdf",
+	},
+}
+
+// chachaKAT pins the fast-key-erasure construction: instantiate, generate
+// 64 bytes, generate 64 bytes with additional input 0102, compare the second
+// output. Cross-checked against the OpenSSL chacha20 cipher.
+var chachaKAT = drbgKAT{
+	name:      "ChaCha20 fast-key-erasure",
+	algo:      AlgoChaCha20,
+	mech:      func() Mechanism { return NewChaChaDRBG() },
+	entropy:   "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+	personal:  "656e74726f70792d73657276696365",
+	addition2: "0102",
+	returned: "876d2fe28dead1f24d7e16c05664ce16c379e7be4672b3c5cbe51efd04e8d9e3" +
+		"31aa0c191fc03c0db796e57e84a38b2ae622cd3966f3410f54565a4479285b26",
+}
+
+// chachaSelfTest runs the ChaCha20 KAT and checks backtracking resistance:
+// the state left after Generate must not contain the key that produced the
+// output, and replaying from that state must not reproduce the output.
+func chachaSelfTest() error {
+	if err := chachaKAT.run(); err != nil {
+		return fmt.Errorf("KAT %q: %w", chachaKAT.name, err)
+	}
+
+	c := NewChaChaDRBG()
+	defer c.Zeroize()
+	if err := c.Instantiate(unhex(chachaKAT.entropy), nil, nil); err != nil {
+		return err
+	}
+
+	prevKey, prevNonce := c.key, c.nonce
+	out := make([]byte, 256)
+	if err := c.Generate(out, nil); err != nil {
+		return err
+	}
+	if c.key == prevKey {
+		return fmt.Errorf("backtracking: key not erased after Generate")
+	}
+	if bytes.Contains(out, c.key[:]) {
+		return fmt.Errorf("backtracking: next key leaked into output")
+	}
+
+	// an attacker holding the post-call state knows the nonce that produced
+	// out, it is one less than the current one; only the erased key is missing
+	state := *c
+	state.nonce = prevNonce
+	replay := make([]byte, len(out))
+	if err := state.Generate(replay, nil); err != nil {
+		return err
+	}
+	if bytes.Equal(replay, out) || state.key == prevKey {
+		return fmt.Errorf("backtracking: previous output recomputed from current state")
+	}
+	return nil
+}
+
+// runKATs runs the known-answer vectors of the named algorithm.
+func runKATs(algo string) error {
+	for _, v := range drbgKATs {
+		if v.algo != algo {
+			continue
+		}
+		if err := v.run(); err != nil {
+			return fmt.Errorf("KAT %q: %w", v.name, err)
+		}
+	}
+	return nil
+}
+
+func (v drbgKAT) run() error {
+	c := v.mech()
+	defer c.Zeroize()
+
+	if err := c.Instantiate(unhex(v.entropy), unhex(v.nonce), unhex(v.personal)); err != nil {
+		return err
+	}
+	if v.entropyReseed != "" {
+		if err := c.Reseed(unhex(v.entropyReseed), unhex(v.additionReseed)); err != nil {
+			return err
+		}
+	}
+
+	want := unhex(v.returned)
+	got := make([]byte, len(want))
+	generate := func(entropyPR, addition string) error {
+		add := unhex(addition)
+		if v.entropyPR1 != "" {
+			if err := c.Reseed(unhex(entropyPR), add); err != nil {
+				return err
+			}
+			add = nil
+		}
+		return c.Generate(got, add)
+	}
+	if err := generate(v.entropyPR1, v.addition1); err != nil {
+		return err
+	}
+	if err := generate(v.entropyPR2, v.addition2); err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("unexpected output %x", got)
+	}
+	return nil
+}
+
+// unhex decodes a compile-time hex constant
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
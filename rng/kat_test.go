@@ -0,0 +1,34 @@
+package rng
+
+import "testing"
+
+// TestDRBGKATs runs every vector of drbgKATs, grouped by algorithm
+func TestDRBGKATs(t *testing.T) {
+	for _, algo := range []string{AlgoCTRAES256, AlgoHMACSHA512, AlgoHashSHA512} {
+		t.Run(algo, func(t *testing.T) {
+			n := 0
+			for _, v := range drbgKATs {
+				if v.algo != algo {
+					continue
+				}
+				n++
+				t.Run(v.name, func(t *testing.T) {
+					if err := v.run(); err != nil {
+						t.Fatal(err)
+					}
+				})
+			}
+			if n == 0 {
+				t.Fatal("no vectors")
+			}
+		})
+	}
+}
+
+func TestRunKATs(t *testing.T) {
+	for _, st := range RunKATs() {
+		if !st.Passed {
+			t.Errorf("%s: %s", st.Name, st.Error)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package rng
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Kernel feeder defaults
+const (
+	DefaultKernelFeedCredit   = 0.5 // bits credited per bit written
+	DefaultKernelFeedMaxBytes = 512 // largest single write
+	kernelFeedMinBytes        = 16
+)
+
+// KernelFeeder writes output of a source into the kernel entropy pool with
+// RNDADDENTROPY, as rngd does. It feeds when the kernel reports it wants
+// entropy (POLLOUT on /dev/random, or entropy_avail below
+// write_wakeup_threshold) and at least every refresh interval otherwise.
+// Each write is credited at a fraction of its length, never more than the
+// pool is missing. In dry-run mode the source is read and the credit
+// computed, but nothing reaches the kernel, which needs no privileges.
+type KernelFeeder struct {
+	mu       sync.Mutex
+	src      QRNG
+	dev      kernelRandom
+	dryRun   bool
+	credit   float64
+	maxBytes int
+	poll     time.Duration // longest wait for the kernel between checks
+	refresh  time.Duration // feed at least this often, 0 to only feed on demand
+	buf      []byte
+
+	writes    uint64
+	bytes     uint64
+	bits      uint64
+	errors    uint64
+	lastErr   error
+	last      time.Time
+	avail     int
+	threshold int
+	poolSize  int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// KernelFeedStats reports what a KernelFeeder wrote and the kernel's view
+type KernelFeedStats struct {
+	DryRun          bool   `json:"dry_run"`
+	Writes          uint64 `json:"writes"`
+	Bytes           uint64 `json:"bytes"`
+	CreditedBits    uint64 `json:"credited_bits"`
+	Errors          uint64 `json:"errors"`
+	LastError       string `json:"last_error,omitempty"`
+	EntropyAvail    int    `json:"entropy_avail"`
+	WakeupThreshold int    `json:"write_wakeup_threshold"`
+	PoolSize        int    `json:"poolsize"`
+}
+
+// kernelRandom is the platform side of a KernelFeeder
+type kernelRandom interface {
+	wait(timeout time.Duration) bool // true if the kernel asks for entropy
+	add(p []byte, bits int) error    // RNDADDENTROPY
+	entropy() (avail, thr, pool int, err error)
+	close() error
+}
+
+// NewKernelFeeder returns a feeder reading src, call Start to run it
+func NewKernelFeeder(src QRNG, dryRun bool) (*KernelFeeder, error) {
+	dev, err := openKernelRandom(dryRun)
+	if err != nil {
+		return nil, err
+	}
+	return &KernelFeeder{
+		src:      src,
+		dev:      dev,
+		dryRun:   dryRun,
+		credit:   DefaultKernelFeedCredit,
+		maxBytes: DefaultKernelFeedMaxBytes,
+		poll:     time.Second,
+		refresh:  time.Minute,
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// SetCredit sets the entropy bits credited per bit written, in (0, 1]
+func (k *KernelFeeder) SetCredit(c float64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if c > 0 && c <= 1 {
+		k.credit = c
+	}
+}
+
+// SetMaxBytes bounds a single write
+func (k *KernelFeeder) SetMaxBytes(n int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.maxBytes = max(n, kernelFeedMinBytes)
+}
+
+// SetIntervals sets how long to wait for the kernel between checks and how
+// often to feed when it asks for nothing
+func (k *KernelFeeder) SetIntervals(poll, refresh time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if poll > 0 {
+		k.poll = poll
+	}
+	k.refresh = refresh
+}
+
+// Start runs the feeder until Stop
+func (k *KernelFeeder) Start() {
+	go k.loop()
+}
+
+// Stop ends the feeder and closes /dev/random
+func (k *KernelFeeder) Stop() {
+	k.stopOnce.Do(func() { close(k.stop) })
+}
+
+func (k *KernelFeeder) loop() {
+	defer k.dev.close()
+
+	for {
+		select {
+		case <-k.stop:
+			return
+		default:
+		}
+
+		k.mu.Lock()
+		poll := k.poll
+		k.mu.Unlock()
+		wanted := k.dev.wait(poll)
+
+		select {
+		case <-k.stop:
+			return
+		default:
+		}
+		if !k.feed(wanted) {
+			// source or kernel failing, do not spin on a pending POLLOUT
+			select {
+			case <-k.stop:
+				return
+			case <-time.After(poll):
+			}
+		}
+	}
+}
+
+// feed tops up the kernel pool if it is wanted or due, it returns false
+// after an error. Only the loop calls it, so k.buf needs no lock.
+func (k *KernelFeeder) feed(wanted bool) bool {
+	avail, thr, pool, err := k.dev.entropy()
+	if err != nil {
+		k.failed(err)
+		return false
+	}
+
+	k.mu.Lock()
+	k.avail, k.threshold, k.poolSize = avail, thr, pool
+	deficit := thr - avail
+	due := k.refresh > 0 && time.Since(k.last) >= k.refresh
+	credit, maxBytes := k.credit, k.maxBytes
+	k.mu.Unlock()
+	if deficit <= 0 && !wanted && !due {
+		return true
+	}
+
+	// enough bytes to cover the deficit at the credit ratio
+	n := kernelFeedMinBytes
+	if deficit > 0 {
+		n = int(math.Ceil(float64(deficit) / (8 * credit)))
+	}
+	n = min(max(n, kernelFeedMinBytes), maxBytes)
+	if cap(k.buf) < n {
+		k.buf = make([]byte, maxBytes)
+	}
+	buf := k.buf[:n]
+	defer clear(buf)
+
+	if err := k.src.Read(buf); err != nil {
+		k.failed(err)
+		return false
+	}
+	bits := int(float64(8*n) * credit)
+	bits = max(min(bits, pool-avail), 0)
+	if !k.dryRun {
+		if err := k.dev.add(buf, bits); err != nil {
+			k.failed(err)
+			return false
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.writes++
+	k.bytes += uint64(n)
+	k.bits += uint64(bits)
+	k.last = time.Now()
+	k.lastErr = nil
+	return true
+}
+
+func (k *KernelFeeder) failed(err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.errors++
+	k.lastErr = err
+}
+
+// Stats returns the counters and the kernel values seen last
+func (k *KernelFeeder) Stats() KernelFeedStats {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	s := KernelFeedStats{
+		DryRun:          k.dryRun,
+		Writes:          k.writes,
+		Bytes:           k.bytes,
+		CreditedBits:    k.bits,
+		Errors:          k.errors,
+		EntropyAvail:    k.avail,
+		WakeupThreshold: k.threshold,
+		PoolSize:        k.poolSize,
+	}
+	if k.lastErr != nil {
+		s.LastError = k.lastErr.Error()
+	}
+	return s
+}
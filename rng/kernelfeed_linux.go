@@ -0,0 +1,89 @@
+package rng
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	kernelRandomDevice = "/dev/random"
+	kernelRandomProc   = "/proc/sys/kernel/random/"
+)
+
+// devRandom is /dev/random, opened read-only in dry-run mode where it is
+// only polled
+type devRandom struct {
+	f   *os.File
+	buf []byte // struct rand_pool_info followed by the data
+}
+
+func openKernelRandom(dryRun bool) (kernelRandom, error) {
+	flag := os.O_RDWR
+	if dryRun {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(kernelRandomDevice, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &devRandom{f: f}, nil
+}
+
+// wait polls for POLLOUT, which the kernel raises when it wants entropy:
+// below write_wakeup_threshold before 5.18, until the CRNG is ready after
+func (d *devRandom) wait(timeout time.Duration) bool {
+	fds := []unix.PollFd{{Fd: int32(d.f.Fd()), Events: unix.POLLOUT}}
+	n, err := unix.Poll(fds, int(timeout.Milliseconds()))
+	return err == nil && n > 0 && fds[0].Revents&unix.POLLOUT != 0
+}
+
+// add issues RNDADDENTROPY, which needs CAP_SYS_ADMIN
+func (d *devRandom) add(p []byte, bits int) error {
+	// struct rand_pool_info { int entropy_count; int buf_size; __u32 buf[]; }
+	n := 8 + (len(p)+3)&^3
+	if cap(d.buf) < n {
+		d.buf = make([]byte, n)
+	}
+	buf := d.buf[:n]
+	defer clear(buf)
+	binary.NativeEndian.PutUint32(buf[0:], uint32(bits))
+	binary.NativeEndian.PutUint32(buf[4:], uint32(len(p)))
+	copy(buf[8:], p)
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, d.f.Fd(), unix.RNDADDENTROPY, uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		return fmt.Errorf("rng: RNDADDENTROPY: %w", errno)
+	}
+	return nil
+}
+
+func (d *devRandom) entropy() (avail, thr, pool int, err error) {
+	for _, v := range []struct {
+		name string
+		dst  *int
+	}{
+		{"entropy_avail", &avail},
+		{"write_wakeup_threshold", &thr},
+		{"poolsize", &pool},
+	} {
+		b, err := os.ReadFile(kernelRandomProc + v.name)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if *v.dst, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+			return 0, 0, 0, fmt.Errorf("rng: %s: %w", v.name, err)
+		}
+	}
+	return avail, thr, pool, nil
+}
+
+func (d *devRandom) close() error {
+	return d.f.Close()
+}
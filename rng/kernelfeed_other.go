@@ -0,0 +1,9 @@
+//go:build !linux
+
+package rng
+
+import "errors"
+
+func openKernelRandom(dryRun bool) (kernelRandom, error) {
+	return nil, errors.New("rng: feeding the kernel pool is only supported on Linux")
+}
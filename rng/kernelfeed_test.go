@@ -0,0 +1,117 @@
+package rng
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKernel reports fixed pool values and records RNDADDENTROPY calls
+type fakeKernel struct {
+	mu                     sync.Mutex
+	avail, threshold, pool int
+	wanted                 bool
+	adds                   []int // bytes written per call
+	bits                   []int // bits credited per call
+}
+
+func (f *fakeKernel) wait(timeout time.Duration) bool {
+	time.Sleep(time.Millisecond)
+	return f.wanted
+}
+
+func (f *fakeKernel) add(p []byte, bits int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.adds = append(f.adds, len(p))
+	f.bits = append(f.bits, bits)
+	return nil
+}
+
+func (f *fakeKernel) entropy() (avail, thr, pool int, err error) {
+	return f.avail, f.threshold, f.pool, nil
+}
+
+func (f *fakeKernel) close() error { return nil }
+
+func newTestFeeder(dev *fakeKernel, dryRun bool) *KernelFeeder {
+	return &KernelFeeder{
+		src:      &countingQRNG{},
+		dev:      dev,
+		dryRun:   dryRun,
+		credit:   DefaultKernelFeedCredit,
+		maxBytes: DefaultKernelFeedMaxBytes,
+		poll:     time.Millisecond,
+		stop:     make(chan struct{}),
+	}
+}
+
+func TestKernelFeederSizing(t *testing.T) {
+	for _, tc := range []struct {
+		name                   string
+		avail, threshold, pool int
+		wanted                 bool
+		bytes, bits            int // 0 bytes: no write expected
+	}{
+		// 924 bits missing at 0.5 bits per bit written is 231 bytes
+		{"deficit", 100, 1024, 4096, false, 231, 924},
+		// the deficit would need 1024 bytes, one write is capped
+		{"max bytes", 0, 4096, 4096, false, DefaultKernelFeedMaxBytes, 4 * DefaultKernelFeedMaxBytes},
+		// the minimum write credits 64 bits, only 6 fit in the pool
+		{"clamp to pool", 4090, 4096, 4096, false, kernelFeedMinBytes, 6},
+		// asked for by POLLOUT above the threshold, the pool is full
+		{"wanted full pool", 256, 256, 256, true, kernelFeedMinBytes, 0},
+		{"not wanted", 2048, 1024, 4096, false, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dev := &fakeKernel{avail: tc.avail, threshold: tc.threshold, pool: tc.pool}
+			k := newTestFeeder(dev, false)
+			if !k.feed(tc.wanted) {
+				t.Fatal(k.Stats().LastError)
+			}
+
+			if tc.bytes == 0 {
+				if len(dev.adds) != 0 {
+					t.Fatalf("wrote %v, want nothing", dev.adds)
+				}
+				return
+			}
+			if len(dev.adds) != 1 || dev.adds[0] != tc.bytes || dev.bits[0] != tc.bits {
+				t.Fatalf("wrote %v bytes crediting %v bits, want %d crediting %d", dev.adds, dev.bits, tc.bytes, tc.bits)
+			}
+			s := k.Stats()
+			if s.Writes != 1 || s.Bytes != uint64(tc.bytes) || s.CreditedBits != uint64(tc.bits) {
+				t.Errorf("stats %+v", s)
+			}
+			if s.EntropyAvail != tc.avail || s.WakeupThreshold != tc.threshold || s.PoolSize != tc.pool {
+				t.Errorf("kernel values %+v", s)
+			}
+		})
+	}
+}
+
+func TestKernelFeederDryRun(t *testing.T) {
+	dev := &fakeKernel{avail: 0, threshold: 1024, pool: 4096, wanted: true}
+	k := newTestFeeder(dev, true)
+	k.Start()
+
+	deadline := time.Now().Add(time.Second)
+	for k.Stats().Writes < 3 {
+		if time.Now().After(deadline) {
+			k.Stop()
+			t.Fatalf("stats %+v", k.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	k.Stop()
+
+	s := k.Stats()
+	if !s.DryRun || s.CreditedBits != s.Writes*1024 {
+		t.Errorf("stats %+v, want 1024 bits credited per write", s)
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if len(dev.adds) != 0 {
+		t.Errorf("dry run wrote %v to the kernel", dev.adds)
+	}
+}
@@ -0,0 +1,319 @@
+package rng
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Network source schemes
+const (
+	SchemeTCP            = "tcp"             // raw byte stream
+	SchemeTLS            = "tls"             // raw byte stream over TLS
+	SchemeHTTP           = "http"            // GET per read, see NetSource
+	SchemeHTTPS          = "https"           // as http, over TLS
+	SchemeEntropyService = "entropy-service" // another entropy-service's /v1/random over TLS
+)
+
+// Network source defaults
+const (
+	DefaultNetTimeout    = 5 * time.Second
+	DefaultNetMaxRequest = 64 << 10 // largest single HTTP GET
+)
+
+// ErrPinMismatch is returned when no certificate of the peer matches a pin
+var ErrPinMismatch = errors.New("rng: peer certificate does not match any pin")
+
+// NetSourceConfig configures the transport of a NetSource
+type NetSourceConfig struct {
+	Timeout    time.Duration  // dial, handshake and each read; DefaultNetTimeout if 0
+	MaxRequest int            // largest single HTTP GET; DefaultNetMaxRequest if 0
+	RootCAs    *x509.CertPool // nil for the system roots
+	Pins       [][]byte       // SHA-256 of a SubjectPublicKeyInfo, see ParsePins
+}
+
+// ParsePins parses comma separated SHA-256 SPKI pins, each base64 with an
+// optional sha256/ prefix (as HPKP) or hex
+func ParsePins(s string) ([][]byte, error) {
+	var pins [][]byte
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimPrefix(strings.TrimSpace(p), "sha256/")
+		if p == "" {
+			continue
+		}
+		pin, err := hex.DecodeString(p)
+		if err != nil {
+			pin, err = base64.StdEncoding.DecodeString(p)
+		}
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("rng: invalid pin %q, want a SHA-256 in hex or base64", p)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// SPKIPin returns the pin of a certificate, for ParsePins
+func SPKIPin(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return sum[:]
+}
+
+// IsNetSource reports whether spec is a network source URL
+func IsNetSource(spec string) bool {
+	scheme, _, ok := strings.Cut(spec, "://")
+	if !ok {
+		return false
+	}
+	switch scheme {
+	case SchemeTCP, SchemeTLS, SchemeHTTP, SchemeHTTPS, SchemeEntropyService:
+		return true
+	}
+	return false
+}
+
+// NetSource pulls entropy from a remote appliance or another
+// entropy-service, so one machine with hardware can seed many:
+//
+//	tcp://host:port, tls://host:port    raw bytes, one long-lived connection
+//	http(s)://host/path                 GET per read, the length replaces
+//	                                    {n} in the URL or is appended as ?bytes=
+//	entropy-service://host[:port]       https://host:8443/v1/random?bytes=n
+//
+// With pins, a TLS peer is accepted if any certificate of its chain
+// matches; without RootCAs a matching leaf is enough, which suits the
+// self-signed certificates appliances ship with. A failed connection is
+// dropped and dialed again on the next Read, with one retry per Read so a
+// connection the peer closed while idle costs no error.
+type NetSource struct {
+	mu      sync.Mutex
+	scheme  string
+	addr    string // host:port of a stream
+	url     string // of an HTTP source
+	timeout time.Duration
+	maxReq  int
+	tls     *tls.Config
+	conn    net.Conn
+	client  *http.Client
+}
+
+// NewNetSource returns the source at spec, connecting on the first Read
+func NewNetSource(spec string, cfg NetSourceConfig) (*NetSource, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("rng: network source %q: %w", spec, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("rng: network source %q has no host", spec)
+	}
+	s := &NetSource{
+		scheme:  u.Scheme,
+		timeout: cfg.Timeout,
+		maxReq:  cfg.MaxRequest,
+	}
+	if s.timeout <= 0 {
+		s.timeout = DefaultNetTimeout
+	}
+	if s.maxReq <= 0 {
+		s.maxReq = DefaultNetMaxRequest
+	}
+
+	switch u.Scheme {
+	case SchemeTCP, SchemeTLS:
+		if u.Port() == "" {
+			return nil, fmt.Errorf("rng: network source %q has no port", spec)
+		}
+		s.addr = u.Host
+	case SchemeEntropyService:
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "8443")
+		}
+		s.url = "https://" + host + "/v1/random?bytes={n}"
+	case SchemeHTTP, SchemeHTTPS:
+		s.url = spec
+		if !strings.Contains(spec, "{n}") {
+			sep := "?"
+			if u.RawQuery != "" {
+				sep = "&"
+			}
+			s.url += sep + "bytes={n}"
+		}
+	default:
+		return nil, fmt.Errorf("rng: unknown network source scheme %q", u.Scheme)
+	}
+
+	if u.Scheme != SchemeTCP && u.Scheme != SchemeHTTP {
+		s.tls = netTLSConfig(u.Hostname(), cfg)
+	}
+	if s.url != "" {
+		s.client = &http.Client{
+			Timeout: s.timeout,
+			Transport: &http.Transport{
+				DialContext:         (&net.Dialer{Timeout: s.timeout}).DialContext,
+				TLSClientConfig:     s.tls,
+				TLSHandshakeTimeout: s.timeout,
+				MaxIdleConnsPerHost: 1,
+				ForceAttemptHTTP2:   true,
+			},
+		}
+	}
+	return s, nil
+}
+
+// netTLSConfig verifies the peer against the roots and the pins
+func netTLSConfig(host string, cfg NetSourceConfig) *tls.Config {
+	c := &tls.Config{
+		ServerName: host,
+		RootCAs:    cfg.RootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(cfg.Pins) == 0 {
+		return c
+	}
+
+	pinned := func(certs []*x509.Certificate) bool {
+		for _, cert := range certs {
+			pin := SPKIPin(cert)
+			for _, want := range cfg.Pins {
+				if bytes.Equal(pin, want) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if cfg.RootCAs == nil {
+		// the pin alone authenticates the leaf
+		c.InsecureSkipVerify = true
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 || !pinned(cs.PeerCertificates[:1]) {
+				return ErrPinMismatch
+			}
+			return nil
+		}
+		return c
+	}
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		for _, chain := range cs.VerifiedChains {
+			if pinned(chain) {
+				return nil
+			}
+		}
+		return ErrPinMismatch
+	}
+	return c
+}
+
+// Name returns where the source reads from
+func (s *NetSource) Name() string {
+	if s.url != "" {
+		return strings.Split(s.url, "?")[0]
+	}
+	return s.scheme + "://" + s.addr
+}
+
+// Read fills p from the remote end
+func (s *NetSource) Read(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	read := s.readStream
+	if s.client != nil {
+		read = s.readHTTP
+	}
+	for len(p) > 0 {
+		n, err := read(p)
+		if err != nil {
+			// the connection may have gone stale while idle, retry once
+			s.drop()
+			if n, err = read(p); err != nil {
+				s.drop()
+				return fmt.Errorf("rng: %s: %w", s.Name(), err)
+			}
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// readStream fills p from the stream connection, dialing it if needed
+func (s *NetSource) readStream(p []byte) (int, error) {
+	if s.conn == nil {
+		d := &net.Dialer{Timeout: s.timeout}
+		var conn net.Conn
+		var err error
+		if s.tls != nil {
+			conn, err = (&tls.Dialer{NetDialer: d, Config: s.tls}).Dial("tcp", s.addr)
+		} else {
+			conn, err = d.Dial("tcp", s.addr)
+		}
+		if err != nil {
+			return 0, err
+		}
+		s.conn = conn
+	}
+	s.conn.SetReadDeadline(time.Now().Add(s.timeout))
+	return io.ReadFull(s.conn, p)
+}
+
+// readHTTP fetches up to MaxRequest bytes of p with one GET
+func (s *NetSource) readHTTP(p []byte) (int, error) {
+	n := min(len(p), s.maxReq)
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.ReplaceAll(s.url, "{n}", strconv.Itoa(n)), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/octet-stream")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return 0, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	got, err := io.ReadFull(resp.Body, p[:n])
+	if err != nil {
+		clear(p[:got])
+		return 0, fmt.Errorf("short response, %d of %d bytes: %w", got, n, err)
+	}
+	return n, nil
+}
+
+// drop closes the connections so the next read dials again, s.mu held
+func (s *NetSource) drop() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.client != nil {
+		s.client.CloseIdleConnections()
+	}
+}
+
+// Close closes the connection, a later Read dials again
+func (s *NetSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop()
+	return nil
+}
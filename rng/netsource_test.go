@@ -0,0 +1,253 @@
+package rng
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newEntropyServer serves ?bytes=n bytes of 0xab on /v1/random over TLS and
+// 404 elsewhere, counting the requests
+func newEntropyServer(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var requests atomic.Int64
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/random" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		n, _ := strconv.Atoi(r.URL.Query().Get("bytes"))
+		w.Write(bytes.Repeat([]byte{0xab}, n))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestNetSourceEntropyService(t *testing.T) {
+	srv, requests := newEntropyServer(t)
+	pin := SPKIPin(srv.Certificate())
+
+	s, err := NewNetSource("entropy-service://"+srv.Listener.Addr().String(),
+		NetSourceConfig{MaxRequest: 1000, Pins: [][]byte{pin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	p := make([]byte, 2500)
+	if err := s.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, bytes.Repeat([]byte{0xab}, len(p))) {
+		t.Error("unexpected content")
+	}
+	// 1000 + 1000 + 500
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests for 2500 bytes at MaxRequest 1000, want 3", n)
+	}
+}
+
+func TestNetSourcePins(t *testing.T) {
+	srv, _ := newEntropyServer(t)
+	url := "https://" + srv.Listener.Addr().String() + "/v1/random"
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	pin := SPKIPin(srv.Certificate())
+	wrong := bytes.Repeat([]byte{1}, len(pin))
+
+	for _, tc := range []struct {
+		name    string
+		cfg     NetSourceConfig
+		wantErr error // nil for success
+		anyErr  bool
+	}{
+		{name: "pin", cfg: NetSourceConfig{Pins: [][]byte{wrong, pin}}},
+		{name: "wrong pin", cfg: NetSourceConfig{Pins: [][]byte{wrong}}, wantErr: ErrPinMismatch},
+		{name: "no pin, unknown CA", anyErr: true},
+		{name: "CA", cfg: NetSourceConfig{RootCAs: roots}},
+		{name: "CA and pin", cfg: NetSourceConfig{RootCAs: roots, Pins: [][]byte{pin}}},
+		{name: "CA and wrong pin", cfg: NetSourceConfig{RootCAs: roots, Pins: [][]byte{wrong}}, wantErr: ErrPinMismatch},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Timeout = 2 * time.Second
+			s, err := NewNetSource(url, tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			err = s.Read(make([]byte, 32))
+			switch {
+			case tc.wantErr != nil && !errors.Is(err, tc.wantErr):
+				t.Fatalf("got %v, want %v", err, tc.wantErr)
+			case tc.anyErr && err == nil:
+				t.Fatal("connected to an unverified peer")
+			case tc.wantErr == nil && !tc.anyErr && err != nil:
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestNetSourceHTTPError(t *testing.T) {
+	srv, _ := newEntropyServer(t)
+	s, err := NewNetSource("https://"+srv.Listener.Addr().String()+"/missing",
+		NetSourceConfig{Pins: [][]byte{SPKIPin(srv.Certificate())}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Read(make([]byte, 32)); err == nil || !strings.Contains(err.Error(), "HTTP 404") {
+		t.Fatalf("got %v, want an HTTP 404 error", err)
+	}
+}
+
+func TestNetSourceHTTPLengthInPath(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.Path)
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/raw/"))
+		w.Write(make([]byte, n))
+	}))
+	defer srv.Close()
+
+	s, err := NewNetSource(srv.URL+"/raw/{n}", NetSourceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Read(make([]byte, 48)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "/raw/48" {
+		t.Fatalf("requested %v, want [/raw/48]", got)
+	}
+}
+
+// serveStream accepts connections on ln and writes n bytes of fill to each
+// before hanging up
+func serveStream(ln net.Listener, n int, fill byte, conns *atomic.Int64) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conns.Add(1)
+		go func() {
+			defer c.Close()
+			c.Write(bytes.Repeat([]byte{fill}, n))
+		}()
+	}
+}
+
+func TestNetSourceTCPReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var conns atomic.Int64
+	go serveStream(ln, 100, 0x5a, &conns)
+
+	s, err := NewNetSource("tcp://"+ln.Addr().String(), NetSourceConfig{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// the second read hits the end of the first connection and redials
+	p := make([]byte, 60)
+	for i := 0; i < 2; i++ {
+		if err := s.Read(p); err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		if !bytes.Equal(p, bytes.Repeat([]byte{0x5a}, len(p))) {
+			t.Fatalf("read %d: unexpected content", i)
+		}
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("%d connections, want 2", n)
+	}
+}
+
+func TestNetSourceTLSStream(t *testing.T) {
+	srv, _ := newEntropyServer(t)
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := tls.NewListener(tcp, srv.TLS)
+	defer ln.Close()
+	var conns atomic.Int64
+	go serveStream(ln, 1024, 0x33, &conns)
+
+	s, err := NewNetSource("tls://"+ln.Addr().String(),
+		NetSourceConfig{Pins: [][]byte{SPKIPin(srv.Certificate())}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	p := make([]byte, 512)
+	if err := s.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, bytes.Repeat([]byte{0x33}, len(p))) {
+		t.Fatal("unexpected content")
+	}
+}
+
+func TestParsePins(t *testing.T) {
+	sum := bytes.Repeat([]byte{0xc3}, 32)
+	b64 := base64.StdEncoding.EncodeToString(sum)
+	pins, err := ParsePins(" sha256/" + b64 + ", " + hex.EncodeToString(sum) + ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 2 || !bytes.Equal(pins[0], sum) || !bytes.Equal(pins[1], sum) {
+		t.Fatalf("got %x", pins)
+	}
+
+	for _, bad := range []string{"xyz", hex.EncodeToString(sum[:16])} {
+		if _, err := ParsePins(bad); err == nil {
+			t.Errorf("ParsePins(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestNewNetSourceSpecs(t *testing.T) {
+	for _, spec := range []string{"tcp://host", "ftp://host:21", "tls://", "%"} {
+		if _, err := NewNetSource(spec, NetSourceConfig{}); err == nil {
+			t.Errorf("NewNetSource(%q) succeeded", spec)
+		}
+	}
+	for spec, want := range map[string]bool{
+		"tcp://h:1":            true,
+		"entropy-service://h":  true,
+		"/dev/qrandom0":        false,
+		"getrandom:nonblock":   false,
+		"unix:///run/egd-pool": false,
+	} {
+		if IsNetSource(spec) != want {
+			t.Errorf("IsNetSource(%q) = %v", spec, !want)
+		}
+	}
+
+	s, err := NewNetSource("entropy-service://rng.example", NetSourceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := s.Name(); name != "https://rng.example:8443/v1/random" {
+		t.Errorf("Name() = %q", name)
+	}
+}
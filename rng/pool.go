@@ -0,0 +1,141 @@
+package rng
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Pool spreads Generate calls over independently seeded DRBG shards so
+// concurrent requests do not serialize on a single mutex. It implements
+// Generator and can be used wherever a master DRBG is expected.
+type Pool struct {
+	shards []*DRBG
+	next   atomic.Uint64
+}
+
+// poolSeedLen is the seed drawn per shard, same as the master seed in main
+const poolSeedLen = 64
+
+// NewPool returns n shards running algo, each instantiated from its own
+// poolSeedLen bytes returned by seed
+func NewPool(algo string, n int, seed func(n int) ([]byte, error)) (*Pool, error) {
+	if n < 1 {
+		return nil, errors.New("rng: pool needs at least one shard")
+	}
+	p := &Pool{shards: make([]*DRBG, n)}
+	for i := range p.shards {
+		s, err := seed(poolSeedLen)
+		if err != nil {
+			p.Zeroize()
+			return nil, err
+		}
+		d, err := NewDRBGWithAlgo(algo, s)
+		clear(s)
+		if err != nil {
+			p.Zeroize()
+			return nil, err
+		}
+		p.shards[i] = d
+	}
+	return p, nil
+}
+
+// Shards returns the individual generators, e.g. to report on each one
+func (p *Pool) Shards() []*DRBG {
+	return p.shards
+}
+
+// shard picks the next shard round-robin
+func (p *Pool) shard() *DRBG {
+	return p.shards[(p.next.Add(1)-1)%uint64(len(p.shards))]
+}
+
+// Instantiate instantiates every shard, the shard index is appended to the
+// personalization string so shards never share state
+func (p *Pool) Instantiate(entropy, nonce, personalization []byte) error {
+	for i, d := range p.shards {
+		if err := d.Instantiate(entropy, nonce, shardInput(personalization, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reseed mixes the same entropy into every shard, with the shard index
+// appended to the additional input so the shards stay distinct
+func (p *Pool) Reseed(entropy, additional []byte) error {
+	for i, d := range p.shards {
+		if err := d.Reseed(entropy, shardInput(additional, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generate fills out from one shard
+func (p *Pool) Generate(out, additional []byte) error {
+	return p.shard().Generate(out, additional)
+}
+
+// Zeroize wipes every shard
+func (p *Pool) Zeroize() {
+	for _, d := range p.shards {
+		if d != nil {
+			d.Zeroize()
+		}
+	}
+}
+
+// SetLimits sets the per-key limits of every shard
+func (p *Pool) SetLimits(maxGenerates, maxBytes uint64) {
+	for _, d := range p.shards {
+		d.SetLimits(maxGenerates, maxBytes)
+	}
+}
+
+// SetEntropyBuffer attaches the buffer used for forced reseeds to every shard
+func (p *Pool) SetEntropyBuffer(q *QRNGBuffer) {
+	for _, d := range p.shards {
+		d.SetEntropyBuffer(q)
+	}
+}
+
+// SetConditioner makes forced reseeds of every shard draw conditioned input
+func (p *Pool) SetConditioner(c *Conditioner) {
+	for _, d := range p.shards {
+		d.SetConditioner(c)
+	}
+}
+
+// SetMetadata sets the header metadata of every shard
+func (p *Pool) SetMetadata(version, source string, interval time.Duration, sizeBits int, buf *QRNGBuffer) {
+	for _, d := range p.shards {
+		d.SetMetadata(version, source, interval, sizeBits, buf)
+	}
+}
+
+// Metadata reports the first shard, with the reseed age and the counts since
+// reseed of the shard closest to its limits, which apply per shard, and the
+// reseed and refusal totals summed over all shards
+func (p *Pool) Metadata() Metadata {
+	m := p.shards[0].Metadata()
+	for _, d := range p.shards[1:] {
+		s := d.Metadata()
+		m.ReseedAge = max(m.ReseedAge, s.ReseedAge)
+		m.GenerateCount = max(m.GenerateCount, s.GenerateCount)
+		m.BytesSinceReseed = max(m.BytesSinceReseed, s.BytesSinceReseed)
+		m.ForcedReseeds += s.ForcedReseeds
+		m.RefusedRequests += s.RefusedRequests
+	}
+	m.Shards = len(p.shards)
+	return m
+}
+
+// shardInput returns in followed by the big-endian shard index
+func shardInput(in []byte, i int) []byte {
+	out := make([]byte, len(in), len(in)+4)
+	copy(out, in)
+	return binary.BigEndian.AppendUint32(out, uint32(i))
+}
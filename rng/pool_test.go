@@ -0,0 +1,113 @@
+package rng
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// newTestPool returns a pool of n ChaCha20 shards with distinct seeds
+func newTestPool(tb testing.TB, n int) *Pool {
+	tb.Helper()
+	var i byte
+	p, err := NewPool(AlgoChaCha20, n, func(n int) ([]byte, error) {
+		s := make([]byte, n)
+		i++
+		s[0] = i
+		return s, nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return p
+}
+
+func TestPoolShardsDiffer(t *testing.T) {
+	p := newTestPool(t, 4)
+	defer p.Zeroize()
+	if err := p.Reseed(make([]byte, 64), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, d := range p.Shards() {
+		out := make([]byte, 32)
+		if err := d.Generate(out, nil); err != nil {
+			t.Fatal(err)
+		}
+		if seen[string(out)] {
+			t.Fatal("two shards produced the same output after a shared reseed")
+		}
+		seen[string(out)] = true
+	}
+}
+
+// TestPoolMetadata checks that the counts since reseed are comparable to the
+// per-shard limits: they come from the busiest shard, not a sum
+func TestPoolMetadata(t *testing.T) {
+	p := newTestPool(t, 4)
+	defer p.Zeroize()
+	p.SetLimits(100, 1000)
+
+	shards := p.Shards()
+	for i, n := range []int{100, 300} {
+		if err := shards[i].Generate(make([]byte, n), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range 3 {
+		if err := shards[2].Generate(make([]byte, 10), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := p.Metadata()
+	if m.BytesSinceReseed != 300 || m.GenerateCount != 3 {
+		t.Errorf("%d bytes, %d generates since reseed, want 300 and 3", m.BytesSinceReseed, m.GenerateCount)
+	}
+	if m.MaxBytesPerReseed != 1000 || m.MaxGenerates != 100 || m.Shards != 4 {
+		t.Errorf("limits %d bytes, %d generates over %d shards", m.MaxBytesPerReseed, m.MaxGenerates, m.Shards)
+	}
+}
+
+// BenchmarkPoolGenerate measures 4 KiB requests from g goroutines, against a
+// single shard and one shard per GOMAXPROCS
+func BenchmarkPoolGenerate(b *testing.B) {
+	const size = 4096
+	shards := []int{1}
+	if n := runtime.GOMAXPROCS(0); n > 1 {
+		shards = append(shards, n)
+	}
+	for _, shards := range shards {
+		for _, g := range []int{1, 8, 64} {
+			b.Run(fmt.Sprintf("shards=%d/goroutines=%d", shards, g), func(b *testing.B) {
+				p := newTestPool(b, shards)
+				defer p.Zeroize()
+				b.SetBytes(size)
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				var wg sync.WaitGroup
+				for w := 0; w < g; w++ {
+					n := b.N / g
+					if w < b.N%g {
+						n++
+					}
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						out := make([]byte, size)
+						for range n {
+							if err := p.Generate(out, nil); err != nil {
+								b.Error(err)
+								return
+							}
+						}
+					}()
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
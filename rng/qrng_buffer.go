@@ -1,131 +1,506 @@
 package rng
 
 import (
-	"os"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"sync"
 	"time"
-	"errors"
-	//"sync/atomic"
 )
 
-// QRNG represents a hardware or network QRNG
+// QRNG represents a hardware or network QRNG. Read fills p completely or
+// returns an error.
 type QRNG interface {
 	Read(p []byte) error
 }
 
-// PCIe card driver stub
-type QRNGCard struct {
-	// add fields if needed for device handle
+// readerQRNG adapts an io.Reader to QRNG
+type readerQRNG struct {
+	r io.Reader
 }
 
-// NewQRNGCard returns a new QRNG interface
-func NewQRNGCard() *QRNGCard {
-	return &QRNGCard{}
+// FromReader returns a QRNG reading from r (crypto/rand.Reader, a net.Conn,
+// a bytes.Reader in tests, ...)
+func FromReader(r io.Reader) QRNG {
+	return readerQRNG{r: r}
 }
 
-// Read fills p with true random bytes from the card
-func (q *QRNGCard) Read(p []byte) error {
-	// TODO: Replace this with real QRNG API calls
-	// For example:
-	// n, err := q.card.Read(p)
-	// if err != nil { return err }
-	// if n != len(p) { return errors.New("short read") }
-	// return nil
-
-	return errors.New("QRNG Read not implemented yet")
+func (s readerQRNG) Read(p []byte) error {
+	_, err := io.ReadFull(s.r, p)
+	return err
 }
 
-// QRNGBuffer holds bytes read asynchronously from a QRNG device
+// fillChunk is the most read from the source per fill cycle, so the buffer
+// becomes usable before a large capacity is completely filled
+const fillChunk = 64 << 10
+
+// QRNGBuffer holds bytes read asynchronously from a QRNG source in a fixed
+// ring. The fill goroutine refills it from the low watermark up to the high
+// one; readers waiting for bytes are woken as soon as they arrive.
 type QRNGBuffer struct {
-	buf       []byte       // the current entropy buffer
-	mu        sync.Mutex   // protects buf
-	capacity  int          // max buffer size in bytes
-	fillDelay time.Duration // small delay to avoid busy-wait
-	devPath   string       // path to QRNG device, e.g., /dev/qrandom0
-	stop      chan struct{} // used to signal background goroutine to exit
+	mu       sync.Mutex // protects the ring and the stats
+	ready    sync.Cond  // bytes were added, or the buffer stopped
+	drained  sync.Cond  // the fill level dropped below the low watermark, or stopped
+	ring     []byte     // fixed storage, never reallocated
+	head     int        // index of the next byte to consume
+	n        int        // bytes buffered
+	capacity int        // max buffer size in bytes
+	low      int        // refilling starts below this many bytes
+	high     int        // refilling stops at this many bytes
+	waiting  int        // readers blocked on more bytes than are buffered
+	want     int        // bytes the largest waiting request needs
+	src      QRNG       // where entropy comes from, kept open between fills
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  bool // set under mu once stop is closed
+
+	// Source statistics
+	bytesRead  uint64
+	readErrors uint64
+	lastErr    error
+	srcErr     error // why the source is failing right now, nil once it delivers again
+
+	// SP 800-90B continuous health tests, run by fillLoop only
+	health      *HealthTests
+	healthSrc   string // source the test state belongs to
+	rejected    uint64 // blocks dropped by a failing test
+	consecutive int    // failing blocks in a row
+	healthErr   error  // most recent failure
+
+	// online min-entropy estimation of the blocks that passed, per source
+	estimators map[string]*EntropyEstimator
 }
 
+// healthFailLimit is the number of failing blocks in a row after which the
+// source is considered failed and, behind a Failover, quarantined
+const healthFailLimit = 3
 
-// NewQRNGBuffer creates a new buffered QRNG reader
-func NewQRNGBuffer(dev string, capacity int) *QRNGBuffer {
+// Health states reported by QRNGBuffer.Stats
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded" // the last block failed a health test
+	HealthFailed   = "failed"   // healthFailLimit blocks in a row failed
+)
+
+// SourceQuarantiner is implemented by sources that can take one of their
+// inputs out of rotation, see Failover
+type SourceQuarantiner interface {
+	Quarantine(name string, err error)
+}
+
+// QRNGBufferStats reports how the source behind a buffer is doing
+type QRNGBufferStats struct {
+	Buffered      int    // bytes ready to be consumed
+	Capacity      int    // max bytes buffered
+	LowWatermark  int    // refilling starts below this
+	HighWatermark int    // refilling stops here
+	BytesRead     uint64 // bytes read from the source so far
+	ReadErrors    uint64 // failed reads from the source
+	LastError     string // most recent read error, empty if none yet
+	SourceError   string // why the source is failing right now, empty while it delivers
+
+	Health         string // HealthOK, HealthDegraded or HealthFailed
+	HealthError    string // most recent health test failure
+	RCTFailures    uint64
+	APTFailures    uint64
+	RejectedBlocks uint64
+	RCTCutoff      int
+	APTCutoff      int
+	MinEntropy     float64 // claimed bits per byte the cutoffs derive from
+}
+
+// Buffer errors. A cancelled context is reported as its own error.
+var (
+	// ErrTimeout is returned when the buffer cannot supply entropy in time
+	ErrTimeout = errors.New("rng: entropy buffer timeout")
+	// ErrShutdown is returned to readers once the buffer is stopped
+	ErrShutdown = errors.New("rng: entropy buffer shut down")
+	// ErrSourceUnavailable is returned when the buffer runs short while its
+	// source is failing, instead of waiting for a source that may never return
+	ErrSourceUnavailable = errors.New("rng: entropy source unavailable")
+	// ErrBufferTooSmall is returned for requests larger than the buffer
+	ErrBufferTooSmall = errors.New("rng: request exceeds entropy buffer capacity")
+)
+
+// NewQRNGBuffer creates a new buffered reader on src. It refills whenever
+// less than half of capacity is buffered, see SetWatermarks.
+func NewQRNGBuffer(src QRNG, capacity int) *QRNGBuffer {
 	q := &QRNGBuffer{
-		buf:       make([]byte, 0, capacity),
-		capacity:  capacity,
-		fillDelay: 10 * time.Millisecond,
-		devPath:   dev,
-		stop:      make(chan struct{}),
+		ring:     make([]byte, capacity),
+		capacity: capacity,
+		low:      capacity / 2,
+		high:     capacity,
+		src:      src,
+		stop:     make(chan struct{}),
+		health:   NewHealthTests(DefaultMinEntropy),
+
+		estimators: make(map[string]*EntropyEstimator),
 	}
+	q.ready.L = &q.mu
+	q.drained.L = &q.mu
 
 	// Start the background goroutine to fill the buffer
 	go q.fillLoop()
 	return q
 }
 
-// Stop signals the background goroutine to exit
-func (q *QRNGBuffer) Stop() {
-	close(q.stop)
+// SetMinEntropy replaces the health tests with ones whose cutoffs derive
+// from a claimed min-entropy of h bits per byte
+func (q *QRNGBuffer) SetMinEntropy(h float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.health = NewHealthTests(h)
 }
 
-// Get returns n bytes from the buffer, blocking if necessary
-func (q *QRNGBuffer) Get(n int) ([]byte, error) {
+// SetWatermarks makes the buffer start refilling when fewer than low bytes
+// are buffered and stop once high bytes are, 0 <= low <= high <= capacity
+func (q *QRNGBuffer) SetWatermarks(low, high int) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
+	q.high = min(max(high, 1), q.capacity)
+	q.low = min(max(low, 0), q.high)
+	q.drained.Signal()
+}
 
-	// Wait until enough bytes are available
-	for len(q.buf) < n {
-		q.mu.Unlock()
-		time.Sleep(q.fillDelay)
+// Stop signals the background goroutine to exit and wakes every waiting
+// reader, it is safe to call twice
+func (q *QRNGBuffer) Stop() {
+	q.stopOnce.Do(func() {
+		close(q.stop)
 		q.mu.Lock()
+		q.stopped = true
+		q.ready.Broadcast()
+		q.drained.Broadcast()
+		q.mu.Unlock()
+	})
+}
+
+// Zeroize stops the fill goroutine and wipes everything still buffered
+func (q *QRNGBuffer) Zeroize() {
+	q.Stop()
+	q.mu.Lock()
+	clear(q.ring)
+	q.head, q.n = 0, 0
+	for _, e := range q.estimators {
+		e.Zeroize()
+	}
+	q.mu.Unlock()
+}
+
+// Get returns n bytes from the buffer, blocking until they are available,
+// the source fails or the buffer is stopped
+func (q *QRNGBuffer) Get(n int) ([]byte, error) {
+	return q.GetContext(context.Background(), n)
+}
+
+// GetContext returns n bytes from the buffer, waiting until they are
+// available or ctx is done. It fails with ErrTimeout when the deadline of ctx
+// passes, ErrSourceUnavailable when the source fails meanwhile and
+// ErrShutdown once the buffer is stopped.
+func (q *QRNGBuffer) GetContext(ctx context.Context, n int) ([]byte, error) {
+	out := make([]byte, n)
+	if err := q.ReadContext(ctx, out); err != nil {
+		return nil, err
 	}
+	return out, nil
+}
 
-	// Take the first n bytes
-	out := q.buf[:n]
-	q.buf = q.buf[n:]
+// ReadContext is GetContext filling p
+func (q *QRNGBuffer) ReadContext(ctx context.Context, p []byte) error {
+	return q.read(ctx, p, -1)
+}
+
+// TryGet returns n bytes from the buffer only if they are available right away
+func (q *QRNGBuffer) TryGet(n int) ([]byte, bool) {
+	out := make([]byte, n)
+	if !q.TryRead(out) {
+		return nil, false
+	}
+	return out, true
+}
+
+// TryRead fills p only if enough bytes are buffered right away
+func (q *QRNGBuffer) TryRead(p []byte) bool {
+	return q.read(nil, p, 0) == nil
+}
+
+// GetTimeout returns n bytes from the buffer, waiting at most timeout for them
+func (q *QRNGBuffer) GetTimeout(n int, timeout time.Duration) ([]byte, error) {
+	out := make([]byte, n)
+	if err := q.read(nil, out, timeout); err != nil {
+		return nil, err
+	}
 	return out, nil
 }
 
-// fillLoop continuously fills the buffer from the QRNG device
+// ReadTimeout fills p from the buffer, waiting at most timeout. It does not
+// allocate unless it has to wait.
+func (q *QRNGBuffer) ReadTimeout(p []byte, timeout time.Duration) error {
+	return q.read(nil, p, timeout)
+}
+
+// bufferReadTimeout bounds Read, so a stalled source cannot hang its caller
+const bufferReadTimeout = time.Second
+
+// Read fills p from the buffer, which makes a QRNGBuffer usable as a QRNG
+// source itself (e.g. for an Accumulator)
+func (q *QRNGBuffer) Read(p []byte) error {
+	return q.read(nil, p, bufferReadTimeout)
+}
+
+// read waits until len(p) bytes are buffered and moves them into p, or
+// until ctx (if not nil) is done. A negative timeout waits forever, zero
+// does not wait.
+func (q *QRNGBuffer) read(ctx context.Context, p []byte, timeout time.Duration) error {
+	if len(p) > q.capacity {
+		return ErrBufferTooSmall
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var expired bool
+	if q.n < len(p) && timeout != 0 && q.srcErr == nil {
+		// sync.Cond cannot time out, a timer or ctx wakes the waiters instead
+		wake := func() {
+			q.mu.Lock()
+			expired = true
+			q.ready.Broadcast()
+			q.mu.Unlock()
+		}
+		if timeout > 0 {
+			t := time.AfterFunc(timeout, wake)
+			defer t.Stop()
+		}
+		if ctx != nil {
+			defer context.AfterFunc(ctx, wake)()
+		}
+
+		// a reader short of bytes above the low watermark refills too
+		q.waiting++
+		q.want = max(q.want, len(p))
+		q.drained.Signal()
+		for q.n < len(p) && !expired && !q.stopped && q.srcErr == nil {
+			q.ready.Wait()
+		}
+		if q.waiting--; q.waiting == 0 {
+			q.want = 0
+		}
+	}
+	switch {
+	case q.n >= len(p):
+	case q.stopped:
+		return ErrShutdown
+	case q.srcErr != nil:
+		return fmt.Errorf("%w: %v", ErrSourceUnavailable, q.srcErr)
+	case ctx != nil && errors.Is(ctx.Err(), context.Canceled):
+		return ctx.Err()
+	default:
+		return ErrTimeout
+	}
+
+	// copy out of the ring, wiping what was consumed
+	for done := 0; done < len(p); {
+		chunk := q.ring[q.head:min(q.head+len(p)-done, q.capacity)]
+		copy(p[done:], chunk)
+		clear(chunk)
+		done += len(chunk)
+		q.head = (q.head + len(chunk)) % q.capacity
+	}
+	q.n -= len(p)
+	if q.n < q.low || q.n < q.want {
+		q.drained.Signal()
+	}
+	return nil
+}
+
+// Len returns the number of bytes ready to be consumed
+func (q *QRNGBuffer) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// SourceName returns the name of the source currently feeding the buffer,
+// empty if the source cannot tell (see SourceNamer)
+func (q *QRNGBuffer) SourceName() string {
+	if n, ok := q.src.(SourceNamer); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+// Stats returns the fill level and source statistics
+func (q *QRNGBuffer) Stats() QRNGBufferStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	st := QRNGBufferStats{
+		Buffered:      q.n,
+		Capacity:      q.capacity,
+		LowWatermark:  q.low,
+		HighWatermark: q.high,
+		BytesRead:     q.bytesRead,
+		ReadErrors:    q.readErrors,
+	}
+	if q.lastErr != nil {
+		st.LastError = q.lastErr.Error()
+	}
+	if q.srcErr != nil {
+		st.SourceError = q.srcErr.Error()
+	}
+
+	st.Health = HealthOK
+	switch {
+	case q.consecutive >= healthFailLimit:
+		st.Health = HealthFailed
+	case q.consecutive > 0:
+		st.Health = HealthDegraded
+	}
+	if q.healthErr != nil {
+		st.HealthError = q.healthErr.Error()
+	}
+	st.RCTFailures, st.APTFailures = q.health.Failures()
+	st.RCTCutoff, st.APTCutoff = q.health.Cutoffs()
+	st.MinEntropy = q.health.minEntropy
+	st.RejectedBlocks = q.rejected
+	return st
+}
+
+// Estimates returns the latest min-entropy estimate of every source that has
+// fed the buffer a full estimate window, by source name
+func (q *QRNGBuffer) Estimates() map[string]Estimate {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	out := make(map[string]Estimate, len(q.estimators))
+	for name, e := range q.estimators {
+		if est, ok := e.Estimate(); ok {
+			out[name] = est
+		}
+	}
+	return out
+}
+
+// fillLoop refills the ring from the QRNG source whenever it drops below
+// the low watermark, until it reaches the high one
 func (q *QRNGBuffer) fillLoop() {
+	tmp := make([]byte, min(q.capacity, fillChunk))
+	defer clear(tmp)
+
+	filling := true
 	for {
-		select {
-		case <-q.stop:
+		q.mu.Lock()
+		for !q.stopped && !filling {
+			if q.n < q.low || q.n < q.want {
+				filling = true
+			} else {
+				q.drained.Wait()
+			}
+		}
+		if q.stopped {
+			q.mu.Unlock()
 			return
-		default:
 		}
-
-		q.mu.Lock()
-		free := q.capacity - len(q.buf)
+		// waiting readers may need more than the high watermark
+		free := max(q.high, q.want) - q.n
 		q.mu.Unlock()
 
 		if free <= 0 {
-			// Buffer is full, sleep a little
-			time.Sleep(q.fillDelay)
+			filling = false
 			continue
 		}
 
-		tmp := make([]byte, free)
-		f, err := os.Open(q.devPath)
-		if err != nil {
-			// Could not open QRNG device, retry after short sleep
-			time.Sleep(50 * time.Millisecond)
+		chunk := tmp[:min(free, len(tmp))]
+		if err := q.src.Read(chunk); err != nil {
+			// Sources reopen their handle on the next Read, retry after short sleep
+			q.mu.Lock()
+			q.readErrors++
+			q.lastErr = err
+			q.srcErr = err
+			q.ready.Broadcast() // waiting readers fail instead of hanging
+			q.mu.Unlock()
+			select {
+			case <-q.stop:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
 			continue
 		}
 
-		total := 0
-		for total < free {
-			m, err := f.Read(tmp[total:])
-			if err != nil { break }
-			total += m
-			//incTest(m)
+		if !q.healthy(chunk) {
+			clear(chunk)
+			continue
 		}
-		//atomic.AddUint64(&rngBytesBuffered, uint64(total))
-		//atomic.AddUint64(&rngBufferSize, uint64(len(total)))
-		f.Close()
+		q.estimator().Sample(chunk)
 
-		// Append new entropy to the buffer
+		// Append new entropy to the ring, unless Zeroize ran meanwhile
 		q.mu.Lock()
-		q.buf = append(q.buf, tmp[:total]...)
+		if q.stopped {
+			q.mu.Unlock()
+			clear(chunk)
+			return
+		}
+		for done := 0; done < len(chunk); {
+			tail := (q.head + q.n) % q.capacity
+			n := copy(q.ring[tail:min(tail+len(chunk)-done, q.capacity)], chunk[done:])
+			done += n
+			q.n += n
+		}
+		q.bytesRead += uint64(len(chunk))
+		q.srcErr = nil
+		filling = q.n < max(q.high, q.want)
+		q.ready.Broadcast()
 		q.mu.Unlock()
+		clear(chunk)
 	}
 }
 
+// estimator returns the estimator of the source the health tests last saw
+func (q *QRNGBuffer) estimator() *EntropyEstimator {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.estimators[q.healthSrc]
+	if !ok {
+		e = NewEntropyEstimator()
+		q.estimators[q.healthSrc] = e
+	}
+	return e
+}
+
+// healthy runs the continuous health tests on a freshly read block. A
+// failing block is rejected; after healthFailLimit in a row the source is
+// quarantined if it supports it.
+func (q *QRNGBuffer) healthy(block []byte) bool {
+	name := q.SourceName()
+
+	q.mu.Lock()
+	if name != q.healthSrc {
+		// a different source took over, its samples are a new stream
+		q.health.Reset()
+		q.healthSrc = name
+	}
+	err := q.health.Test(block)
+	if err == nil {
+		q.consecutive = 0
+		q.mu.Unlock()
+		return true
+	}
+	q.bytesRead += uint64(len(block))
+	q.rejected++
+	q.consecutive++
+	q.healthErr = err
+	failed := q.consecutive >= healthFailLimit
+	if failed {
+		q.srcErr = err
+		q.ready.Broadcast()
+	}
+	q.mu.Unlock()
+
+	if sq, ok := q.src.(SourceQuarantiner); ok && failed && name != "" {
+		sq.Quarantine(name, err)
+	}
+	return false
+}
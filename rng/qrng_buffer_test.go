@@ -0,0 +1,97 @@
+package rng
+
+import (
+	"crypto/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingQRNG returns the bytes 0, 1, 2, ... wrapping at 256
+type countingQRNG struct {
+	mu   sync.Mutex
+	next byte
+}
+
+func (c *countingQRNG) Read(p []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range p {
+		p[i] = c.next
+		c.next++
+	}
+	return nil
+}
+
+// TestQRNGBufferWrapAround reads a small ring in odd sizes, so both the
+// fill and the read side wrap many times, and checks that the byte stream
+// comes out in order
+func TestQRNGBufferWrapAround(t *testing.T) {
+	const capacity = 100
+	q := NewQRNGBuffer(&countingQRNG{}, capacity)
+	defer q.Stop()
+	q.SetWatermarks(capacity/3, capacity)
+
+	var want byte
+	p := make([]byte, 7)
+	for total := 0; total < 50*capacity; total += len(p) {
+		if err := q.ReadTimeout(p, time.Second); err != nil {
+			t.Fatalf("after %d bytes: %v", total, err)
+		}
+		for i, b := range p {
+			if b != want {
+				t.Fatalf("byte %d is %d, want %d", total+i, b, want)
+			}
+			want++
+		}
+		if n := q.Len(); n > capacity {
+			t.Fatalf("%d bytes buffered in a ring of %d", n, capacity)
+		}
+	}
+
+	// what was consumed is wiped, what is buffered is still in order
+	q.Stop()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := 0; i < capacity-q.n; i++ {
+		if b := q.ring[(q.head+q.n+i)%capacity]; b != 0 {
+			t.Fatalf("consumed byte at %d not wiped", (q.head+q.n+i)%capacity)
+		}
+	}
+	for i := 0; i < q.n; i++ {
+		if b := q.ring[(q.head+i)%capacity]; b != want+byte(i) {
+			t.Fatalf("buffered byte %d is %d, want %d", i, b, want+byte(i))
+		}
+	}
+}
+
+func TestQRNGBufferTooSmall(t *testing.T) {
+	q := NewQRNGBuffer(&countingQRNG{}, 16)
+	defer q.Stop()
+	if err := q.ReadTimeout(make([]byte, 17), time.Second); err != ErrBufferTooSmall {
+		t.Fatalf("got %v, want ErrBufferTooSmall", err)
+	}
+}
+
+// BenchmarkQRNGBufferRead measures concurrent 64 byte reads, the size of a
+// reseed, with the fill goroutine reading crypto/rand
+func BenchmarkQRNGBufferRead(b *testing.B) {
+	q := NewQRNGBuffer(FromReader(rand.Reader), 1<<20)
+	defer q.Stop()
+	for q.Len() < 1<<19 {
+		time.Sleep(time.Millisecond)
+	}
+
+	b.SetBytes(64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		p := make([]byte, 64)
+		for pb.Next() {
+			if err := q.ReadTimeout(p, time.Second); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
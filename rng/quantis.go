@@ -0,0 +1,194 @@
+package rng
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	quantisRetryDelay = 5 * time.Millisecond
+	quantisMaxRetries = 200 // ~1s of EAGAIN or empty reads before giving up
+)
+
+// ErrQRNGClosed is returned by a QRNGCard after Close
+var ErrQRNGClosed = errors.New("rng: QRNG device closed")
+
+// QRNGCard reads from an ID Quantique Quantis character device
+// (/dev/qrandom0 with the PCI/PCIe driver, or a USB card exposed through a
+// kernel driver). The device is kept open and only reopened after a failed
+// read; any file or FIFO producing random bytes works as well, which is how
+// it is tested.
+type QRNGCard struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	closed bool
+}
+
+// QRNGCardInfo is what the Quantis driver reports about the card. The PCI
+// driver has no serial number ioctl, BusDeviceID identifies the card instead.
+type QRNGCardInfo struct {
+	DriverVersion uint32
+	CardCount     uint32
+	BoardVersion  uint32
+	BusDeviceID   uint32
+	ModulesMask   uint32 // modules fitted on the card, one bit each
+	ModulesStatus uint32 // modules currently producing random data
+}
+
+// Healthy reports whether every fitted module is producing random data
+func (i QRNGCardInfo) Healthy() bool {
+	return i.ModulesMask != 0 && i.ModulesStatus&i.ModulesMask == i.ModulesMask
+}
+
+// NewQRNGCard opens the Quantis device at path
+func NewQRNGCard(path string) (*QRNGCard, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &QRNGCard{path: path, f: f}, nil
+}
+
+// NewQRNGDevice is NewQRNGCard without opening the device, which happens on
+// the first Read. A missing device is reported by Read, so it can sit in a
+// Failover and be picked up once it appears.
+func NewQRNGDevice(path string) *QRNGCard {
+	return &QRNGCard{path: path}
+}
+
+// Path returns the device path
+func (q *QRNGCard) Path() string {
+	return q.path
+}
+
+// Name returns the device path, see SourceNamer
+func (q *QRNGCard) Name() string {
+	return q.path
+}
+
+// Check asks the driver whether every module is producing data. Devices that
+// do not answer the Quantis ioctls (ChaosKey, /dev/urandom, FIFOs) pass as
+// long as they can be opened.
+func (q *QRNGCard) Check() error {
+	info, err := q.Info()
+	if errors.Is(err, syscall.ENOTTY) || errors.Is(err, syscall.EINVAL) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Healthy() {
+		return fmt.Errorf("rng: %s: modules %#x of %#x ready", q.path, info.ModulesStatus, info.ModulesMask)
+	}
+	return nil
+}
+
+// Read fills p with true random bytes from the card. Short reads are
+// continued and EAGAIN (non-blocking devices) is retried for about a second;
+// end of file before p is full is an error.
+func (q *QRNGCard) Read(p []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.open(); err != nil {
+		return err
+	}
+
+	total, idle := 0, 0
+	for total < len(p) {
+		n, err := q.f.Read(p[total:])
+		total += n
+
+		switch {
+		case n > 0 && (err == nil || errors.Is(err, syscall.EAGAIN)):
+			idle = 0
+		case err == nil || errors.Is(err, syscall.EAGAIN):
+			if idle++; idle > quantisMaxRetries {
+				q.drop()
+				return fmt.Errorf("rng: %s: no data, %d of %d bytes read", q.path, total, len(p))
+			}
+			time.Sleep(quantisRetryDelay)
+		case err == io.EOF:
+			q.drop()
+			return fmt.Errorf("rng: %s: short read, %d of %d bytes: %w", q.path, total, len(p), io.ErrUnexpectedEOF)
+		default:
+			q.drop()
+			return fmt.Errorf("rng: %s: %w", q.path, err)
+		}
+	}
+	return nil
+}
+
+// Info queries the driver for card version and module status. It fails
+// (ENOTTY, EINVAL) on anything that is not a Quantis device, and with
+// ENOTTY outside Linux.
+func (q *QRNGCard) Info() (QRNGCardInfo, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var info QRNGCardInfo
+	if err := q.open(); err != nil {
+		return info, err
+	}
+
+	for _, req := range []struct {
+		cmd uintptr
+		out *uint32
+	}{
+		{quantisIoctlDriverVersion, &info.DriverVersion},
+		{quantisIoctlCardCount, &info.CardCount},
+		{quantisIoctlBoardVersion, &info.BoardVersion},
+		{quantisIoctlBusDeviceID, &info.BusDeviceID},
+		{quantisIoctlModulesMask, &info.ModulesMask},
+		{quantisIoctlModulesStatus, &info.ModulesStatus},
+	} {
+		if err := quantisIoctl(q.f, req.cmd, req.out); err != nil {
+			return info, fmt.Errorf("rng: %s: ioctl %#x: %w", q.path, req.cmd, err)
+		}
+	}
+	return info, nil
+}
+
+// Close releases the device, Read fails with ErrQRNGClosed afterwards
+func (q *QRNGCard) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	if q.f == nil {
+		return nil
+	}
+	err := q.f.Close()
+	q.f = nil
+	return err
+}
+
+// open (re)opens the device if a previous read failed, q.mu must be held
+func (q *QRNGCard) open() error {
+	if q.closed {
+		return ErrQRNGClosed
+	}
+	if q.f != nil {
+		return nil
+	}
+	f, err := os.OpenFile(q.path, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	q.f = f
+	return nil
+}
+
+// drop closes the handle after a failed read so the next one reopens it
+func (q *QRNGCard) drop() {
+	if q.f != nil {
+		q.f.Close()
+		q.f = nil
+	}
+}
@@ -0,0 +1,36 @@
+package rng
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Quantis PCI/PCIe driver ioctls (quantis_pci_common.h), all _IOR('q', n, unsigned int)
+const (
+	quantisIoctlDriverVersion = 0x80047100
+	quantisIoctlCardCount     = 0x80047101
+	quantisIoctlModulesMask   = 0x80047102
+	quantisIoctlBoardVersion  = 0x80047103
+	quantisIoctlModulesStatus = 0x80047108
+	quantisIoctlBusDeviceID   = 0x80047109
+)
+
+// quantisIoctl reads one unsigned int from the Quantis driver
+func quantisIoctl(f *os.File, cmd uintptr, out *uint32) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, cmd, uintptr(unsafe.Pointer(out)))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
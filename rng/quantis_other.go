@@ -0,0 +1,24 @@
+//go:build !linux
+
+package rng
+
+import (
+	"os"
+	"syscall"
+)
+
+// The Quantis driver ioctls, only issued on Linux
+const (
+	quantisIoctlDriverVersion = iota
+	quantisIoctlCardCount
+	quantisIoctlModulesMask
+	quantisIoctlBoardVersion
+	quantisIoctlModulesStatus
+	quantisIoctlBusDeviceID
+)
+
+// quantisIoctl fails outside Linux as for a device that is not a Quantis
+// card, so Check passes any readable device
+func quantisIoctl(f *os.File, cmd uintptr, out *uint32) error {
+	return syscall.ENOTTY
+}
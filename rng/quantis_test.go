@@ -0,0 +1,76 @@
+package rng
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQRNGCardFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qrandom0")
+	data := []byte("0123456789")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewQRNGCard(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err := q.Check(); err != nil {
+		t.Errorf("Check on a plain file: %v", err)
+	}
+
+	// the handle stays open between reads
+	p := make([]byte, 4)
+	for _, want := range []string{"0123", "4567"} {
+		if err := q.Read(p); err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != want {
+			t.Fatalf("read %q, want %q", p, want)
+		}
+	}
+
+	// two bytes left, end of file is an error and drops the handle
+	err = q.Read(p)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got %v at end of file, want io.ErrUnexpectedEOF", err)
+	}
+	if q.f != nil {
+		t.Fatal("handle kept after a failed read")
+	}
+
+	// the next read reopens from the start
+	if err := q.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "0123" {
+		t.Fatalf("read %q after reopening, want %q", p, "0123")
+	}
+
+	q.Close()
+	if err := q.Read(p); !errors.Is(err, ErrQRNGClosed) {
+		t.Fatalf("got %v after Close, want ErrQRNGClosed", err)
+	}
+}
+
+func TestQRNGDeviceAppears(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qrandom0")
+	q := NewQRNGDevice(path)
+	defer q.Close()
+
+	p := make([]byte, 4)
+	if err := q.Read(p); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v for a missing device, want os.ErrNotExist", err)
+	}
+	if err := os.WriteFile(path, []byte("abcd"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Read(p); err != nil || string(p) != "abcd" {
+		t.Fatalf("read %q, %v once the device exists", p, err)
+	}
+}
@@ -0,0 +1,63 @@
+//go:build unix
+
+package rng
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestQRNGCardFIFO feeds a FIFO in small pieces, so Read has to continue
+// short reads, then hangs up
+func TestQRNGCardFIFO(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fifo")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skip("mkfifo:", err)
+	}
+
+	data := make([]byte, 256)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	writerErr := make(chan error, 1)
+	go func() {
+		w, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			writerErr <- err
+			return
+		}
+		defer w.Close()
+		for rest := data; len(rest) > 0; rest = rest[min(len(rest), 10):] {
+			if _, err := w.Write(rest[:min(len(rest), 10)]); err != nil {
+				writerErr <- err
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		writerErr <- nil
+	}()
+
+	q := NewQRNGDevice(path)
+	defer q.Close()
+	p := make([]byte, 200)
+	if err := q.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, data[:200]) {
+		t.Fatal("bytes out of order across short reads")
+	}
+	if err := <-writerErr; err != nil {
+		t.Fatal(err)
+	}
+
+	// 56 bytes remain, then the writer is gone
+	if err := q.Read(p); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got %v after the writer closed, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,98 @@
+package rng
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DefaultSeedFileSize is the amount of DRBG output saved, as systemd-random-seed
+const DefaultSeedFileSize = 512
+
+// SeedFile persists DRBG output across restarts so a cold start has key
+// material before the entropy sources deliver. A loaded seed is removed
+// right away and must be replaced by a fresh Save once the generator is
+// seeded, so the same seed never serves two boots.
+type SeedFile struct {
+	path string
+	size int
+}
+
+// NewSeedFile returns a seed file of size bytes at path
+func NewSeedFile(path string, size int) *SeedFile {
+	if size <= 0 {
+		size = DefaultSeedFileSize
+	}
+	return &SeedFile{path: path, size: size}
+}
+
+// Path returns where the seed is stored
+func (s *SeedFile) Path() string {
+	return s.path
+}
+
+// Load reads the saved seed and removes the file. It returns an error
+// wrapping os.ErrNotExist when there is none, and refuses a seed that
+// cannot be removed since it would be used again on the next start.
+func (s *SeedFile) Load() ([]byte, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := io.ReadAll(io.LimitReader(f, int64(s.size)))
+	f.Close()
+	if err != nil {
+		clear(seed)
+		return nil, err
+	}
+	if err := os.Remove(s.path); err != nil {
+		clear(seed)
+		return nil, fmt.Errorf("rng: seed file %s not consumed: %w", s.path, err)
+	}
+	if len(seed) == 0 {
+		return nil, fmt.Errorf("rng: seed file %s is empty", s.path)
+	}
+	return seed, nil
+}
+
+// Save atomically replaces the seed file with fresh output of g: the seed is
+// written to a temporary file in the same directory, synced, then renamed
+func (s *SeedFile) Save(g Generator) error {
+	seed := make([]byte, s.size)
+	defer clear(seed)
+	if err := g.Generate(seed, nil); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	err = f.Chmod(0o600)
+	if err == nil {
+		_, err = f.Write(seed)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
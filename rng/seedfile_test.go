@@ -0,0 +1,160 @@
+package rng
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// dirEntries returns the names in dir, to spot leftover temporary files
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// TestSeedFileLoadConsumes checks that a loaded seed cannot be loaded again
+func TestSeedFileLoadConsumes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "random-seed")
+	s := NewSeedFile(path, 64)
+	d := newTestDRBG(t, AlgoChaCha20)
+	defer d.Zeroize()
+
+	if err := s.Save(d); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil || len(saved) != 64 {
+		t.Fatalf("saved %d bytes: %v", len(saved), err)
+	}
+
+	seed, err := s.Load()
+	if err != nil || !bytes.Equal(seed, saved) {
+		t.Fatalf("loaded %x: %v", seed, err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("seed file left after Load: %v", err)
+	}
+	if _, err := s.Load(); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("second Load: got %v, want ErrNotExist", err)
+	}
+}
+
+func TestSeedFileLoadLimits(t *testing.T) {
+	dir := t.TempDir()
+
+	// only size bytes of an oversized seed are used, the file still goes
+	path := filepath.Join(dir, "big")
+	if err := os.WriteFile(path, bytes.Repeat([]byte{1}, 100), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if seed, err := NewSeedFile(path, 64).Load(); err != nil || len(seed) != 64 {
+		t.Fatalf("loaded %d bytes: %v", len(seed), err)
+	}
+
+	// an empty seed is refused, and removed as well
+	path = filepath.Join(dir, "empty")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSeedFile(path, 64).Load(); err == nil {
+		t.Fatal("empty seed loaded")
+	}
+	if names := dirEntries(t, dir); len(names) != 0 {
+		t.Errorf("left %v", names)
+	}
+}
+
+// TestSeedFileSaveAtomic checks that Save replaces the seed by renaming a
+// new file over it: a reader of the old seed keeps seeing it whole, and no
+// temporary file is left
+func TestSeedFileSaveAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "random-seed")
+	old := bytes.Repeat([]byte{0xaa}, DefaultSeedFileSize)
+	if err := os.WriteFile(path, old, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	before, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDRBG(t, AlgoChaCha20)
+	defer d.Zeroize()
+	if err := NewSeedFile(path, 0).Save(d); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Error("seed rewritten in place")
+	}
+	if after.Size() != DefaultSeedFileSize || after.Mode().Perm() != 0o600 {
+		t.Errorf("seed file of %d bytes, mode %v", after.Size(), after.Mode())
+	}
+	if b, err := io.ReadAll(f); err != nil || !bytes.Equal(b, old) {
+		t.Errorf("old seed changed under its reader: %v", err)
+	}
+	if names := dirEntries(t, dir); len(names) != 1 || names[0] != "random-seed" {
+		t.Errorf("directory holds %v", names)
+	}
+}
+
+// TestSeedFileSaveFailure checks that a failed Save keeps the old seed and
+// cleans up its temporary file
+func TestSeedFileSaveFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "random-seed")
+	old := bytes.Repeat([]byte{0xaa}, 64)
+	if err := os.WriteFile(path, old, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSeedFile(path, 64)
+
+	// a generator past its byte limit without a reseed source
+	d := newTestDRBG(t, AlgoChaCha20)
+	defer d.Zeroize()
+	d.SetLimits(0, 1)
+	if err := d.Generate(make([]byte, 1), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(d); !errors.Is(err, ErrReseedRequired) {
+		t.Fatalf("got %v, want ErrReseedRequired", err)
+	}
+	if b, err := os.ReadFile(path); err != nil || !bytes.Equal(b, old) {
+		t.Fatalf("old seed lost: %v", err)
+	}
+
+	// the rename fails when a directory is in the way
+	blocked := filepath.Join(dir, "blocked")
+	if err := os.MkdirAll(filepath.Join(blocked, "x"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	fresh := newTestDRBG(t, AlgoChaCha20)
+	defer fresh.Zeroize()
+	if err := NewSeedFile(blocked, 64).Save(fresh); err == nil {
+		t.Fatal("saved over a directory")
+	}
+	if names := dirEntries(t, dir); len(names) != 2 {
+		t.Errorf("directory holds %v, want the seed and the blocking directory", names)
+	}
+}
@@ -0,0 +1,48 @@
+package rng
+
+// startupSamples is the SP 800-90B 4.3 startup test length, in 8-bit samples
+const startupSamples = 1024
+
+// Self-test kinds
+const (
+	SelfTestKAT           = "kat"
+	SelfTestStartupHealth = "startup_health"
+)
+
+// SelfTest is the outcome of one power-on self-test
+type SelfTest struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+func selfTest(name, kind string, err error) SelfTest {
+	t := SelfTest{Name: name, Kind: kind, Passed: err == nil}
+	if err != nil {
+		t.Error = err.Error()
+	}
+	return t
+}
+
+// RunKATs runs the known-answer test of every registered algorithm
+func RunKATs() []SelfTest {
+	var out []SelfTest
+	for _, name := range Algorithms() {
+		out = append(out, selfTest(name, SelfTestKAT, KnownAnswerTest(name)))
+	}
+	return out
+}
+
+// StartupHealthTest reads 1024 samples from src and runs the continuous
+// health tests over them, with cutoffs for a claimed min-entropy of h
+func StartupHealthTest(name string, src QRNG, h float64) SelfTest {
+	samples := make([]byte, startupSamples)
+	defer clear(samples)
+
+	err := src.Read(samples)
+	if err == nil {
+		err = NewHealthTests(h).Test(samples)
+	}
+	return selfTest(name, SelfTestStartupHealth, err)
+}